@@ -6,6 +6,7 @@ import (
 	"errors"
 	"github.com/cloudfoundry/gosigar"
 	"sort"
+	"sync"
 )
 
 // until stubs are implemented
@@ -22,11 +23,15 @@ type ProcessSummary struct {
 }
 
 type ProcessStatus struct {
-	Summary ProcessSummary
-	Pid     int
-	State   sigar.ProcState
-	Time    sigar.ProcTime
-	Mem     sigar.ProcMem
+	Summary  ProcessSummary
+	Pid      int
+	State    sigar.ProcState
+	Time     sigar.ProcTime
+	Mem      sigar.ProcMem
+	Limits   []ProcessLimit
+	Nice     int
+	Affinity []int
+	Env      []string
 }
 
 type SystemStatus struct {
@@ -75,6 +80,13 @@ type About struct {
 type ActionResult struct {
 	Total  int
 	Errors int
+	// Errors where a process didn't start or stop in time.
+	Timeouts int
+	// What was done to each process, such as stop signals sent.
+	Steps []ActionStep
+	// The processes a rolling restart got through, and those it didn't.
+	Restarted    []string
+	NotRestarted []string
 }
 
 // wrap errors returned by API methods so client can
@@ -96,41 +108,81 @@ func NewAPI(config *ConfigManager) *API {
 // *Process methods apply to a single service
 
 func (c *Control) callAction(name string, r *ActionResult, action *ControlAction) error {
+	nerrors, nsteps := len(action.errors), len(action.steps)
 	err := c.DoAction(name, action)
+	return r.add(action, nerrors, nsteps, err)
+}
 
+// Adds the errors and steps of an action from nerrors and nsteps on, and the
+// error it returned.
+func (r *ActionResult) add(action *ControlAction, nerrors int, nsteps int,
+	err error) error {
 	r.Total++
 	if err != nil {
 		r.Errors++
 		err = &ActionError{err}
 	}
+	for _, err := range action.errors[nerrors:] {
+		if _, ok := err.(*TimeoutError); ok {
+			r.Timeouts++
+		}
+	}
+	r.Steps = append(r.Steps, action.steps[nsteps:]...)
 
 	return err
 }
 
+// Calls the action on each of the named processes at the same time, each
+// with a fork of the action so that its errors and steps are its own.  The
+// results are added in name order, and the errors returned in that order.
+func (c *Control) callActions(names []string, r *ActionResult,
+	action *ControlAction) []error {
+	sort.Strings(names)
+	forks := make([]*ControlAction, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		forks[i] = action.fork()
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			errs[i] = c.DoAction(name, forks[i])
+		}(i, name)
+	}
+	wg.Wait()
+
+	for i := range names {
+		r.add(forks[i], 0, 0, errs[i])
+	}
+	return errs
+}
+
 func (a *API) StartProcess(name string, r *ActionResult) error {
-	return a.Control.callAction(name, r, NewControlAction(ACTION_START))
+	return a.Control.runJob("StartProcess", name, r)
 }
 
 func (a *API) StopProcess(name string, r *ActionResult) error {
-	return a.Control.callAction(name, r, NewControlAction(ACTION_STOP))
+	return a.Control.runJob("StopProcess", name, r)
 }
 
 func (a *API) RestartProcess(name string, r *ActionResult) error {
-	return a.Control.callAction(name, r, NewControlAction(ACTION_RESTART))
+	return a.Control.runJob("RestartProcess", name, r)
 }
 
 func (a *API) MonitorProcess(name string, r *ActionResult) error {
-	return a.Control.callAction(name, r, NewControlAction(ACTION_MONITOR))
+	return a.Control.runJob("MonitorProcess", name, r)
 }
 
 func (a *API) UnmonitorProcess(name string, r *ActionResult) error {
-	return a.Control.callAction(name, r, NewControlAction(ACTION_UNMONITOR))
+	return a.Control.runJob("UnmonitorProcess", name, r)
 }
 
 func (c *Control) processSummary(process *Process, summary *ProcessSummary) {
-	summary.Name = process.Name
+	summary.Name = process.FullName()
 	summary.Running = process.IsRunning()
-	summary.ControlState = *c.State(process)
+	c.statesLock.Lock()
+	summary.ControlState = *c.state(process)
+	c.statesLock.Unlock()
 }
 
 func (c *Control) processStatus(process *Process, status *ProcessStatus) error {
@@ -149,56 +201,69 @@ func (c *Control) processStatus(process *Process, status *ProcessStatus) error {
 	status.State.Get(pid)
 	status.Time.Get(pid)
 	status.Mem.Get(pid)
+	readSpawnStatus(pid, status)
 
 	return nil
 }
 
 func (a *API) StatusProcess(name string, r *ProcessStatus) error {
+	a.Control.configLock.RLock()
+	defer a.Control.configLock.RUnlock()
 	process, err := a.Control.Config().FindProcess(name)
 
 	if err != nil {
 		return err
 	}
 
+	if r.Env, err = process.maskedEnvironment(); err != nil {
+		Log.Warnf("Could not resolve the environment of process %q: %v",
+			process.FullName(), err)
+	}
+
 	return a.Control.processStatus(process, r)
 }
 
 // *Group methods apply to a service group
 
-func (c *Control) groupAction(name string, r *ActionResult, method int) error {
+func (c *Control) groupAction(name string, r *ActionResult,
+	action *ControlAction) error {
 	group, err := c.Config().FindGroup(name)
 
 	if err != nil {
 		return &ActionError{err}
 	}
 
-	action := NewGroupControlAction(method)
-
-	for name := range group.Processes {
-		c.callAction(name, r, action)
+	names := []string{}
+	for _, process := range group.Processes {
+		names = append(names, process.FullName())
+	}
+	if action.method == ACTION_RESTART && group.Rolling != nil {
+		c.rollingRestart(group, r, action)
+		return nil
 	}
+	c.callActions(names, r, action)
 
 	return nil
 }
 
 func (a *API) StartGroup(name string, r *ActionResult) error {
-	return a.Control.groupAction(name, r, ACTION_START)
+	return a.Control.runJob("StartGroup", name, r)
 }
 
 func (a *API) StopGroup(name string, r *ActionResult) error {
-	return a.Control.groupAction(name, r, ACTION_STOP)
+	return a.Control.runJob("StopGroup", name, r)
 }
 
 func (a *API) RestartGroup(name string, r *ActionResult) error {
-	return a.Control.groupAction(name, r, ACTION_RESTART)
+	return a.Control.runJob("RestartGroup", name, r)
 }
 
 func (a *API) MonitorGroup(name string, r *ActionResult) error {
-	return a.Control.groupAction(name, r, ACTION_MONITOR)
+	return a.Control.runJob("MonitorGroup", name, r)
 }
 
 func (a *API) UnmonitorGroup(name string, r *ActionResult) error {
-	return a.Control.groupAction(name, r, ACTION_UNMONITOR)
+	return a.Control.runJob("UnmonitorGroup", name, r)
 }
 
 func (c *Control) groupStatus(group *ProcessGroup,
@@ -215,6 +280,8 @@ func (c *Control) groupStatus(group *ProcessGroup,
 }
 
 func (a *API) StatusGroup(name string, r *ProcessGroupStatus) error {
+	a.Control.configLock.RLock()
+	defer a.Control.configLock.RUnlock()
 	group, err := a.Control.Config().FindGroup(name)
 
 	if err != nil {
@@ -229,37 +296,40 @@ func (a *API) StatusGroup(name string, r *ProcessGroupStatus) error {
 
 // *All methods apply to all services
 
-func (c *Control) allAction(r *ActionResult, method int) error {
-	action := NewGroupControlAction(method)
-	for _, processGroup := range c.Config().ProcessGroups {
-		for name, _ := range processGroup.Processes {
-			c.callAction(name, r, action)
-		}
-	}
+func (c *Control) allAction(unused string, r *ActionResult,
+	action *ControlAction) error {
+	names := []string{}
+	c.Config().VisitProcesses(func(process *Process) bool {
+		names = append(names, process.FullName())
+		return true
+	})
+	c.callActions(names, r, action)
 	return nil
 }
 
 func (a *API) StartAll(unused interface{}, r *ActionResult) error {
-	return a.Control.allAction(r, ACTION_START)
+	return a.Control.runJob("StartAll", "", r)
 }
 
 func (a *API) StopAll(unused interface{}, r *ActionResult) error {
-	return a.Control.allAction(r, ACTION_STOP)
+	return a.Control.runJob("StopAll", "", r)
 }
 
 func (a *API) RestartAll(unused interface{}, r *ActionResult) error {
-	return a.Control.allAction(r, ACTION_RESTART)
+	return a.Control.runJob("RestartAll", "", r)
 }
 
 func (a *API) MonitorAll(unused interface{}, r *ActionResult) error {
-	return a.Control.allAction(r, ACTION_MONITOR)
+	return a.Control.runJob("MonitorAll", "", r)
 }
 
 func (a *API) UnmonitorAll(unused interface{}, r *ActionResult) error {
-	return a.Control.allAction(r, ACTION_UNMONITOR)
+	return a.Control.runJob("UnmonitorAll", "", r)
 }
 
 func (a *API) StatusAll(name string, r *ProcessGroupStatus) error {
+	a.Control.configLock.RLock()
+	defer a.Control.configLock.RUnlock()
 	r.Name = name
 
 	for _, processGroup := range a.Control.Config().ProcessGroups {
@@ -270,6 +340,8 @@ func (a *API) StatusAll(name string, r *ProcessGroupStatus) error {
 }
 
 func (a *API) Summary(unused interface{}, s *Summary) error {
+	a.Control.configLock.RLock()
+	defer a.Control.configLock.RUnlock()
 	for _, group := range a.Control.Config().ProcessGroups {
 		for _, process := range group.Processes {
 			summary := ProcessSummary{}
@@ -289,23 +361,18 @@ func (a *API) About(unused interface{}, about *About) error {
 }
 
 // reload server configuration
-func (a *API) Reload(unused interface{}, r *ActionResult) error {
+func (a *API) Reload(unused interface{}, r *ReloadResult) error {
 	Log.Info("Starting config reload")
 	control := a.Control
 	path := control.ConfigManager.path
-	newConfigManager := ConfigManager{}
+	newConfigManager := &ConfigManager{}
 	if err := newConfigManager.LoadConfig(path); err != nil {
 		return err
 	}
-	control.EventMonitor.Stop()
-	// TODO: Do we have a concurrency issue with this?
-	*control.ConfigManager = newConfigManager
-	if err := control.EventMonitor.Start(control.ConfigManager,
-		control); err != nil {
-		return err
-	}
-	// TODO: Watcher restart
-	Log.Info("Finished config reload")
+	control.Reload(newConfigManager, r)
+	Log.Infof("Finished config reload: %d added, %d removed, %d restarted, "+
+		"%d updated", len(r.Added), len(r.Removed), len(r.Restarted),
+		len(r.Updated))
 	return nil
 }
 
@@ -0,0 +1,253 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A process with a 'cgroup' runs in its own cgroup v2 group, at
+// <cgroup_root>/<group>/<process>, which gonit creates and sets the limits of
+// before each start, and removes once the process has stopped.  The spawn
+// helper moves the program into the cgroup before it execs, so everything
+// the process forks is limited and counted with it.  The watcher alerts
+// whenever the OOM killer kills something in the cgroup.  The parent of
+// cgroup_root must have the memory, cpu and pids controllers enabled for
+// gonit, e.g.:
+//
+//   web:
+//     cgroup:
+//       memory_max: 512mb
+//       cpu_max: 150%
+//       pids_max: 100
+
+const DEFAULT_CGROUP_ROOT = "/sys/fs/cgroup/gonit"
+
+const cgroupControllers = "+memory +cpu +pids"
+
+// The period cpu_max quotas are given over, in microseconds.
+const cgroupCpuPeriod = 100000
+
+// Cgroup limits of a process.  memory_max may use kb, mb and gb units and
+// cpu_max is a percentage of one CPU.  Each may also be 'max'.
+type Cgroup struct {
+	MemoryMax string `yaml:"memory_max"`
+	CpuMax    string `yaml:"cpu_max"`
+	PidsMax   string `yaml:"pids_max"`
+}
+
+// Returns the directory of the process' cgroup.
+func (p *Process) cgroupPath() string {
+	root := p.cgroupRoot
+	if root == "" {
+		root = DEFAULT_CGROUP_ROOT
+	}
+	return filepath.Join(root, p.groupName, p.Name)
+}
+
+// Returns the cgroup interface files to write and their values, in order.
+func (c *Cgroup) settings() ([][2]string, error) {
+	memoryMax, err := parseCgroupMax(c.MemoryMax, func(value string) (string,
+		error) {
+		amount, err := parseLimitAmount(value, true)
+		return strconv.FormatUint(amount, 10), err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("memory_max: %v", err)
+	}
+	cpuMax, err := parseCgroupMax(c.CpuMax, func(value string) (string,
+		error) {
+		amount := value
+		if strings.HasSuffix(amount, "%") {
+			amount = amount[:len(amount)-1]
+		}
+		percent, err := strconv.ParseUint(amount, 10, 32)
+		if err != nil || percent == 0 {
+			return "", fmt.Errorf("'%v' is not a percentage", value)
+		}
+		quota := percent * cgroupCpuPeriod / 100
+		return fmt.Sprintf("%d %d", quota, cgroupCpuPeriod), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cpu_max: %v", err)
+	}
+	pidsMax, err := parseCgroupMax(c.PidsMax, func(value string) (string,
+		error) {
+		if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+			return "", fmt.Errorf("'%v' is not a number", value)
+		}
+		return value, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pids_max: %v", err)
+	}
+	return [][2]string{
+		{"memory.max", memoryMax},
+		{"cpu.max", cpuMax},
+		{"pids.max", pidsMax},
+	}, nil
+}
+
+// Parses a limit that is unset or 'max' for no limit, and otherwise is
+// converted by parse.
+func parseCgroupMax(value string, parse func(string) (string,
+	error)) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "max" || value == "unlimited" {
+		return "max", nil
+	}
+	return parse(value)
+}
+
+// Checks the cgroup limits of a process.
+func (p *Process) validateCgroup() error {
+	if p.Cgroup == nil {
+		return nil
+	}
+	if _, err := p.Cgroup.settings(); err != nil {
+		return fmt.Errorf("Process %v cgroup %v.", p.FullName(), err)
+	}
+	return nil
+}
+
+// Creates the cgroup of the process, if needed, and sets its limits.
+// Limits that aren't configured are reset to 'max'.
+func (p *Process) setupCgroup() error {
+	settings, err := p.Cgroup.settings()
+	if err != nil {
+		return err
+	}
+	path := p.cgroupPath()
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	// the controllers have to be enabled in each parent for the limit files
+	// to exist
+	for _, dir := range []string{filepath.Dir(filepath.Dir(path)),
+		filepath.Dir(path)} {
+		err := writeCgroupFile(dir, "cgroup.subtree_control", cgroupControllers)
+		if err != nil {
+			Log.Debugf("Could not enable controllers in %v: %v", dir, err)
+		}
+	}
+	for _, setting := range settings {
+		if err := writeCgroupFile(path, setting[0], setting[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCgroupFile(dir string, name string, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0644)
+}
+
+// Reads a cgroup file that holds a single number, such as memory.current.
+func readCgroupValue(dir string, name string) (uint64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// Reads one key of a cgroup file of "key value" lines, such as cpu.stat.
+func readCgroupKey(dir string, name string, key string) (uint64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("No %v in %v.", key, filepath.Join(dir, name))
+}
+
+// Gets the memory used by everything in a cgroup.
+func getCgroupMemory(dir string) (uint64, error) {
+	return readCgroupValue(dir, "memory.current")
+}
+
+// Gets the CPU time used by everything in a cgroup, in milliseconds like
+// sigar's proc time.
+func getCgroupProcTime(dir string) (uint64, error) {
+	usec, err := readCgroupKey(dir, "cpu.stat", "usage_usec")
+	return usec / 1000, err
+}
+
+// Gets how many times the OOM killer killed a process in a cgroup.
+func getCgroupOomKills(dir string) (uint64, error) {
+	return readCgroupKey(dir, "memory.events", "oom_kill")
+}
+
+// Returns how many OOM kills a cgroup's count is up on last time it was
+// read.  The count starts over when the cgroup is removed and created again.
+func oomKillsSince(last uint64, count uint64) uint64 {
+	if count < last {
+		return count
+	}
+	return count - last
+}
+
+// Alerts about processes the OOM killer killed in the cgroup of a process
+// since it was last checked, whether or not an oom_kill rule watches for
+// them.  Kills from before gonit first checked don't count.
+func (c *Control) checkOomKills(process *Process) {
+	if process.Cgroup == nil {
+		return
+	}
+	count, err := getCgroupOomKills(process.cgroupPath())
+	if err != nil {
+		return // no cgroup while the process isn't running
+	}
+	var kills uint64
+	c.withState(process, func(state *ProcessState) {
+		if state.hasOomKills {
+			kills = oomKillsSince(state.oomKills, count)
+		}
+		state.oomKills, state.hasOomKills = count, true
+	})
+	if kills == 0 {
+		return
+	}
+
+	description := fmt.Sprintf("The OOM killer killed %d process(es) of %q.",
+		kills, process.FullName())
+	Log.Warn(description)
+	alertMessage := &AlertMessage{
+		Action:      "alert",
+		Rule:        OOM_KILL_NAME + " > 0",
+		Service:     process.FullName(),
+		Description: description,
+		Value:       float64(kills),
+		Date:        time.Now(),
+	}
+	alertMessage.LastExit, alertMessage.LastCommand = process.LastExits()
+	err = sendAlertMessage(c.ConfigManager.Settings, alertMessage)
+	if err != nil {
+		Log.Errorf("Error sending alert for process %q: %v",
+			process.FullName(), err)
+	}
+}
+
+// Removes the cgroup of a process that has stopped or was removed from the
+// config.  It is created again when the process is started.
+func (p *Process) removeCgroup() {
+	if p.Cgroup == nil {
+		return
+	}
+	err := os.Remove(p.cgroupPath())
+	if err != nil && !os.IsNotExist(err) {
+		Log.Warnf("Could not remove cgroup %v of process %q: %v",
+			p.cgroupPath(), p.FullName(), err)
+	}
+}
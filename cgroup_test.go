@@ -0,0 +1,173 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type CgroupSuite struct {
+	root string
+}
+
+var _ = Suite(&CgroupSuite{})
+
+func (s *CgroupSuite) SetUpTest(c *C) {
+	s.root = c.MkDir()
+}
+
+func (s *CgroupSuite) TestSettings(c *C) {
+	cgroup := &Cgroup{MemoryMax: "512mb", CpuMax: "150%"}
+	settings, err := cgroup.settings()
+	c.Check(err, IsNil)
+	c.Check(settings, DeepEquals, [][2]string{
+		{"memory.max", "536870912"},
+		{"cpu.max", "150000 100000"},
+		{"pids.max", "max"},
+	})
+
+	process := &Process{Name: "web", Cgroup: &Cgroup{CpuMax: "lots"}}
+	c.Check(process.validateCgroup(), ErrorMatches,
+		"Process web cgroup cpu_max: 'lots' is not a percentage.")
+	process.Cgroup = &Cgroup{PidsMax: "-1"}
+	c.Check(process.validateCgroup(), ErrorMatches,
+		"Process web cgroup pids_max: '-1' is not a number.")
+}
+
+func (s *CgroupSuite) TestSetupCgroup(c *C) {
+	process := &Process{
+		Name:       "web",
+		groupName:  "app",
+		cgroupRoot: s.root,
+		Cgroup:     &Cgroup{PidsMax: "100"},
+	}
+	c.Assert(process.setupCgroup(), IsNil)
+
+	path := filepath.Join(s.root, "app", "web")
+	c.Check(process.cgroupPath(), Equals, path)
+	pidsMax, err := readCgroupValue(path, "pids.max")
+	c.Check(err, IsNil)
+	c.Check(pidsMax, Equals, uint64(100))
+	memoryMax, _ := ioutil.ReadFile(filepath.Join(path, "memory.max"))
+	c.Check(string(memoryMax), Equals, "max\n")
+	controllers, _ := ioutil.ReadFile(filepath.Join(s.root, "app",
+		"cgroup.subtree_control"))
+	c.Check(string(controllers), Equals, cgroupControllers+"\n")
+}
+
+func (s *CgroupSuite) TestGatherCgroup(c *C) {
+	write := func(name string, value string) {
+		err := ioutil.WriteFile(filepath.Join(s.root, name), []byte(value), 0644)
+		c.Assert(err, IsNil)
+	}
+	write("memory.current", "4096\n")
+	write("memory.events", "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n")
+
+	r := ResourceManager{
+		sigarInterface:  &FakeSigarGetter{memResident: 1024},
+		cachedResources: map[string]uint64{},
+	}
+	interval, _ := time.ParseDuration("1s")
+	memory := &ParsedEvent{resourceName: MEMORY_USED_NAME, interval: interval,
+		cgroup: s.root}
+	value, err := r.GetResource(memory, 1234)
+	c.Check(err, IsNil)
+	c.Check(value, Equals, uint64(4096))
+
+	// kills from before gonit was watching don't count
+	oomKill := &ParsedEvent{resourceName: OOM_KILL_NAME, interval: interval,
+		cgroup: s.root}
+	r.ClearCachedResources()
+	value, err = r.GetResource(oomKill, 1234)
+	c.Check(err, IsNil)
+	c.Check(value, Equals, uint64(0))
+
+	write("memory.events", "low 0\nhigh 0\nmax 5\noom 3\noom_kill 3\n")
+	r.ClearCachedResources()
+	value, err = r.GetResource(oomKill, 1234)
+	c.Check(err, IsNil)
+	c.Check(value, Equals, uint64(2))
+
+	oomKill.cgroup = ""
+	r.ClearCachedResources()
+	_, err = r.GetResource(oomKill, 1234)
+	c.Check(err, ErrorMatches, "Resource oom_kill of process  needs a cgroup.")
+}
+
+func (s *CgroupSuite) TestOomKillRuleNeedsCgroup(c *C) {
+	e := &EventMonitor{}
+	event := &Event{Name: "oom", Rule: "oom_kill > 0"}
+	process := &Process{Name: "web", groupName: "app"}
+	c.Check(e.loadEvent(event, "app", process, "restart"), ErrorMatches,
+		"Rule 'oom_kill > 0' needs the process to have a cgroup.")
+
+	process.Cgroup = &Cgroup{MemoryMax: "1gb"}
+	c.Check(e.loadEvent(event, "app", process, "restart"), IsNil)
+	c.Check(e.events[0].cgroup, Equals, process.cgroupPath())
+}
+
+func (s *CgroupSuite) TestOomKillAlert(c *C) {
+	socket := filepath.Join(s.root, "alerts.sock")
+	listener, err := net.Listen("unix", socket)
+	c.Assert(err, IsNil)
+	defer listener.Close()
+
+	settings := &Settings{AlertTransport: UNIX_SOCKET_TRANSPORT,
+		SocketFile: socket}
+	control := &Control{ConfigManager: &ConfigManager{Settings: settings}}
+	process := &Process{
+		Name:       "web",
+		cgroupRoot: s.root,
+		Cgroup:     &Cgroup{MemoryMax: "1gb"},
+	}
+	control.Config().AddProcess("app", process)
+	path := process.cgroupPath()
+	c.Assert(os.MkdirAll(path, 0755), IsNil)
+	write := func(kills string) {
+		err := ioutil.WriteFile(filepath.Join(path, "memory.events"),
+			[]byte("oom 1\noom_kill "+kills+"\n"), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	// without an oom_kill rule, and not for kills from before the first check
+	write("1")
+	control.checkOomKills(process)
+	alerts := make(chan *AlertMessage)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(alerts)
+			return
+		}
+		defer conn.Close()
+		alert := &AlertMessage{}
+		if json.NewDecoder(conn).Decode(alert) != nil {
+			alert = nil
+		}
+		alerts <- alert
+	}()
+	write("3")
+	control.checkOomKills(process)
+	alert := <-alerts
+	c.Assert(alert, NotNil)
+	c.Check(alert.Service, Equals, "app/web")
+	c.Check(alert.Description, Equals,
+		`The OOM killer killed 2 process(es) of "app/web".`)
+
+	// the cgroup is removed once the process has stopped
+	os.Remove(filepath.Join(path, "memory.events"))
+	result := &ActionResult{}
+	c.Check(control.runJob("StopProcess", process.FullName(), result), IsNil)
+	_, err = os.Stat(path)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	// and its kills count from zero when it is created again
+	c.Check(oomKillsSince(3, 1), Equals, uint64(1))
+	c.Check(oomKillsSince(3, 5), Equals, uint64(2))
+}
@@ -13,7 +13,7 @@ import (
 // When gonit is running as a daemon, will be RPCs;
 // Otherwise, invoke the API in-process via reflection.
 type CliClient interface {
-	Call(action string, name string) (interface{}, error)
+	Call(action string, args interface{}) (interface{}, error)
 	Close() error
 }
 
@@ -29,7 +29,8 @@ type localClient struct {
 }
 
 // Dispatch method via RPC
-func (c *remoteClient) Call(action string, name string) (interface{}, error) {
+func (c *remoteClient) Call(action string,
+	args interface{}) (interface{}, error) {
 	method, err := lookupRpcMethod(c.rcvr, action)
 	if err != nil {
 		return nil, err
@@ -37,7 +38,7 @@ func (c *remoteClient) Call(action string, name string) (interface{}, error) {
 	reply := newRpcReply(method)
 
 	service := reflect.TypeOf(c.rcvr).Elem().Name() + "." + method.Name
-	err = c.client.Call(service, name, reply.Interface())
+	err = c.client.Call(service, args, reply.Interface())
 
 	return reply.Interface(), err
 }
@@ -48,7 +49,8 @@ func (c *remoteClient) Close() error {
 }
 
 // Dispatch method via reflection
-func (c *localClient) Call(action string, name string) (interface{}, error) {
+func (c *localClient) Call(action string,
+	args interface{}) (interface{}, error) {
 	method, err := lookupRpcMethod(c.rcvr, action)
 	if err != nil {
 		return nil, err
@@ -57,7 +59,7 @@ func (c *localClient) Call(action string, name string) (interface{}, error) {
 
 	params := []reflect.Value{
 		reflect.ValueOf(c.rcvr),
-		reflect.ValueOf(name),
+		reflect.ValueOf(args),
 		reply,
 	}
 
@@ -5,6 +5,8 @@ package gonit
 import (
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 	"text/tabwriter"
 	"time"
 )
@@ -36,6 +38,60 @@ func (s *Summary) Print(w io.Writer) {
 	})
 }
 
+func (r *ReloadResult) Print(w io.Writer) {
+	writeTable(w, func(tw io.Writer) {
+		changes := []struct {
+			label string
+			names []string
+		}{
+			{"added", r.Added},
+			{"removed", r.Removed},
+			{"restarted", r.Restarted},
+			{"updated", r.Updated},
+			{"unchanged", r.Unchanged},
+		}
+		for _, change := range changes {
+			for _, name := range change.names {
+				fmt.Fprintf(tw, "Process '%s'\t%s\n", name, change.label)
+			}
+		}
+		if r.Errors != 0 {
+			fmt.Fprintf(tw, "%d of %d actions failed\t\n", r.Errors, r.Total)
+		}
+	})
+}
+
+func (j *Job) Print(w io.Writer) {
+	writeTable(w, func(tw io.Writer) {
+		j.write(tw)
+		for _, step := range j.Steps {
+			fmt.Fprintf(tw, "  %s\t%s\n", step.Process, step.Message)
+		}
+		if len(j.Result.Restarted) != 0 || len(j.Result.NotRestarted) != 0 {
+			fmt.Fprintf(tw, "  restarted\t%s\n",
+				strings.Join(j.Result.Restarted, ", "))
+			fmt.Fprintf(tw, "  not restarted\t%s\n",
+				strings.Join(j.Result.NotRestarted, ", "))
+		}
+		if j.Error != "" {
+			fmt.Fprintf(tw, "  error\t%s\n", j.Error)
+		}
+	})
+}
+
+func (l *JobList) Print(w io.Writer) {
+	writeTable(w, func(tw io.Writer) {
+		for i := range l.Jobs {
+			l.Jobs[i].write(tw)
+		}
+	})
+}
+
+func (j *Job) write(tw io.Writer) {
+	fmt.Fprintf(tw, "Job %d\t%s %s\t%s\t%d steps\n", j.Id, j.Method,
+		j.Name, j.State, len(j.Steps))
+}
+
 func writeTable(w io.Writer, f func(io.Writer)) {
 	tw := new(tabwriter.Writer)
 	tw.Init(w, 0, 8, 8, ' ', 0)
@@ -67,9 +123,33 @@ func (p *ProcessSummary) runningString() string {
 	if p.Running {
 		return "running"
 	}
+	restart := p.ControlState.Restart
+	if restart != nil && restart.Failed != 0 {
+		return "failed"
+	}
 	return "not running"
 }
 
+// Describes the restarts of the process by the watcher, if any.
+func (p *ProcessSummary) restartString() string {
+	restart := p.ControlState.Restart
+	if restart == nil {
+		return ""
+	}
+	format := func(ms int64) string {
+		return time.Unix(0, ms*int64(time.Millisecond)).Format(time.RFC3339)
+	}
+	if restart.Failed != 0 {
+		return fmt.Sprintf("failed at %v after %d restarts",
+			format(restart.Failed), len(restart.Restarts))
+	}
+	s := fmt.Sprintf("%d recent restarts", len(restart.Restarts))
+	if restart.Next > time.Now().UnixNano()/int64(time.Millisecond) {
+		s += ", next held back until " + format(restart.Next)
+	}
+	return s
+}
+
 func (p *ProcessStatus) uptime() string {
 	if p.Time.StartTime == 0 {
 		return "-"
@@ -78,6 +158,24 @@ func (p *ProcessStatus) uptime() string {
 	return time.Since(start).String()
 }
 
+func (p *ProcessStatus) affinityString() string {
+	if len(p.Affinity) == 0 {
+		return "-"
+	}
+	cpus := make([]string, len(p.Affinity))
+	for i, cpu := range p.Affinity {
+		cpus[i] = strconv.Itoa(cpu)
+	}
+	return strings.Join(cpus, ",")
+}
+
+func limitString(limit uint64) string {
+	if limit == RLIM_INFINITY {
+		return "unlimited"
+	}
+	return strconv.FormatUint(limit, 10)
+}
+
 func (p *ProcessSummary) write(tw io.Writer) {
 	fmt.Fprintf(tw, "Process '%s'\t%s\n", p.Name, p.runningString())
 }
@@ -104,5 +202,32 @@ func (p *ProcessStatus) write(tw io.Writer) {
 		fmt.Fprintf(tw, "  %s\t%v\n", entry.label, entry.data)
 	}
 
+	state := &p.Summary.ControlState
+	if restarts := p.Summary.restartString(); restarts != "" {
+		fmt.Fprintf(tw, "  %s\t%v\n", "restarts", restarts)
+	}
+	if state.LastExit != nil {
+		fmt.Fprintf(tw, "  %s\t%v\n", "last exit", state.LastExit)
+	}
+	if state.LastCommand != nil {
+		fmt.Fprintf(tw, "  %s\t%v\n", "last command", state.LastCommand)
+		if output := strings.TrimSpace(state.LastCommand.Output); output != "" {
+			fmt.Fprintf(tw, "  %s\t%q\n", "last command output", output)
+		}
+	}
+
+	if p.Pid != 0 {
+		fmt.Fprintf(tw, "  %s\t%v\n", "nice", p.Nice)
+		fmt.Fprintf(tw, "  %s\t%v\n", "cpu affinity", p.affinityString())
+	}
+	for _, limit := range p.Limits {
+		fmt.Fprintf(tw, "  limit %s\t%s / %s\n", limit.Name,
+			limitString(limit.Soft), limitString(limit.Hard))
+	}
+	for _, variable := range p.Env {
+		eq := strings.Index(variable, "=")
+		fmt.Fprintf(tw, "  env %s\t%s\n", variable[:eq], variable[eq+1:])
+	}
+
 	fmt.Fprintf(tw, "\t\n")
 }
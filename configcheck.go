@@ -0,0 +1,101 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Loads the config at path and runs every check that can be done without
+// starting the daemon.  Unlike LoadConfig, which stops at the first kind of
+// problem, all problems found are returned.
+func (c *ConfigManager) CheckConfig(path string) ConfigErrors {
+	errs := ConfigErrors{}
+	if err := c.parse(path); err != nil {
+		errs.add(err)
+		return errs
+	}
+	errs.add(c.checkSchemaErrors())
+	c.applyDefaultConfigOpts()
+	errs.add(c.validate())
+	for _, pg := range c.sortedGroups() {
+		for _, process := range pg.sortedProcesses() {
+			errs.add(process.validatePaths())
+		}
+	}
+	if logFile := c.Settings.Logging.FileName; logFile != "" {
+		errs.add(validateWritableFile("Settings logging", logFile))
+	}
+	return errs
+}
+
+// Checks that the programs of a process can be found, the same way they are
+// looked up when they are run.
+func (p *Process) validateExecutables() error {
+	env, err := p.environment(nil)
+	if err != nil {
+		return nil // the environment is checked on its own
+	}
+	errs := ConfigErrors{}
+	for _, command := range p.commands() {
+		argv, err := p.commandArgs(command[1])
+		if err != nil {
+			continue // reported by validateCommands
+		}
+		if _, err := p.lookPath(argv[0], env); err != nil {
+			errs.add(fmt.Errorf("Process %v %v program '%v' not found: "+
+				"%v.", p.FullName(), command[0], argv[0], err))
+		}
+	}
+	return errs.errOrNil()
+}
+
+// Checks that the pidfile and log files of a process can be written.
+func (p *Process) validatePaths() error {
+	what := "Process " + p.FullName()
+	errs := ConfigErrors{}
+	if p.Pidfile != "" {
+		errs.add(validateWritableDir(what+" pidfile", p.Pidfile))
+	}
+	if p.Stdout != "" {
+		errs.add(validateWritableFile(what+" stdout", p.Stdout))
+	}
+	if p.Stderr != "" && p.Stderr != p.Stdout {
+		errs.add(validateWritableFile(what+" stderr", p.Stderr))
+	}
+	if p.EnvFile != "" {
+		if _, err := readEnvFile(p.EnvFile); err != nil {
+			errs.add(fmt.Errorf("%v env_file: %v.", what, err))
+		}
+	}
+	if p.Chroot != "" {
+		if info, err := os.Stat(p.Chroot); err != nil || !info.IsDir() {
+			errs.add(fmt.Errorf("%v chroot '%v' is not a directory.", what,
+				p.Chroot))
+		}
+	}
+	return errs.errOrNil()
+}
+
+// Checks that a file could be created in the directory of path.
+func validateWritableDir(what string, path string) error {
+	dir := filepath.Dir(path)
+	if err := syscall.Access(dir, 0x2 /* W_OK */); err != nil {
+		return fmt.Errorf("%v '%v' is not writable: %v.", what, path, err)
+	}
+	return nil
+}
+
+// Checks that path can be appended to, or created if it doesn't exist.
+func validateWritableFile(what string, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return validateWritableDir(what, path)
+	}
+	if err := syscall.Access(path, 0x2 /* W_OK */); err != nil {
+		return fmt.Errorf("%v '%v' is not writable: %v.", what, path, err)
+	}
+	return nil
+}
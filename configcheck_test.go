@@ -0,0 +1,103 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+)
+
+type ConfigCheckSuite struct{}
+
+var _ = Suite(&ConfigCheckSuite{})
+
+const brokenConfig = `---
+processes:
+  web:
+    description: web server
+    pidfile: /does/not/exist/web.pid
+    start: /bin/web
+    stop: /bin/web 'stop
+    uid: gonit_no_such_user
+    dependson:
+      - db
+    actions:
+      alert:
+        - memory_high
+        - no_such_event
+      reboot:
+        - cpu_high
+      restart:
+        - cpu_high
+  worker:
+    pidfile: /tmp/worker.pid
+events:
+  memory_high:
+    description: The memory for a process is too high.
+    rule: memory_used > 5lb
+  cpu_high:
+    description: cpu is too high.
+    rule: cpu_percent > 50
+    interval: 1s
+    duration: 1s
+`
+
+func (s *ConfigCheckSuite) TestCheckConfig(c *C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "web-gonit.yml")
+	if err := ioutil.WriteFile(path, []byte(brokenConfig), 0644); err != nil {
+		c.Fatal(err)
+	}
+
+	configManager := &ConfigManager{}
+	errs := configManager.CheckConfig(path)
+	messages := []string{}
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	c.Check(messages, DeepEquals, []string{
+		"worker must have name, description, pidfile and start.",
+		"Event memory_high: Invalid units 'lb' on 'memory_used'.",
+		"Process web/web has an unknown event 'no_such_event' on action " +
+			"'alert'.",
+		"Process web/web has an invalid action 'reboot'.  Valid actions are " +
+			"[stop, start, restart, alert].",
+		"Process web/web event 'cpu_high' on action 'restart': Rule " +
+			"'cpu_percent > 50' duration / interval must be greater than 1.  " +
+			"It is '1 / 1'.",
+		"Process web/web has an unknown uid 'gonit_no_such_user'.",
+		"Process web/web stop: Unterminated single quote in '/bin/web 'stop'.",
+		"Process web/web start program '/bin/web' not found: exec: " +
+			"\"/bin/web\": stat /bin/web: no such file or directory.",
+		"Process web/web has an unknown dependson 'db'.",
+		"Process web/web pidfile '/does/not/exist/web.pid' is not writable: " +
+			"no such file or directory.",
+	})
+
+	// LoadConfig checks everything but the files the processes write
+	err := configManager.LoadConfig(path)
+	c.Check(err, NotNil)
+	c.Check(len(err.(ConfigErrors)), Equals, 9)
+}
+
+func (s *ConfigCheckSuite) TestExecutables(c *C) {
+	dir := c.MkDir()
+	bin := filepath.Join(dir, "bin")
+	c.Assert(os.Mkdir(bin, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(bin, "tool"), nil, 0755), IsNil)
+
+	// looked up in the PATH of the process, not gonit's
+	process := &Process{Name: "tool", Start: "tool start"}
+	c.Check(process.validateExecutables(), ErrorMatches,
+		`Process tool start program 'tool' not found: .*\$PATH\.`)
+	process.Env = []string{"PATH=" + bin}
+	c.Check(process.validateExecutables(), IsNil)
+
+	// and relative to its dir
+	process = &Process{Name: "tool", Start: "bin/tool start"}
+	c.Check(process.validateExecutables(), NotNil)
+	process.Dir = dir
+	c.Check(process.validateExecutables(), IsNil)
+}
@@ -4,11 +4,11 @@ package gonit
 
 import (
 	"fmt"
-	"io/ioutil"
-	"launchpad.net/goyaml"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
 // TODO:
@@ -21,6 +21,7 @@ type ConfigManager struct {
 	ProcessGroups map[string]*ProcessGroup
 	Settings      *Settings
 	path          string
+	schemaErrors  ConfigErrors
 }
 
 type Settings struct {
@@ -31,12 +32,19 @@ type Settings struct {
 	Daemon              *Process
 	PersistFile         string
 	Logging             *LoggerConfig
+	UnknownKeys         string
+	StartTimeout        string `yaml:"start_timeout"`
+	StopTimeout         string `yaml:"stop_timeout"`
+	RestartTimeout      string `yaml:"restart_timeout"`
+	CgroupRoot          string `yaml:"cgroup_root"`
+	ActionConcurrency   int    `yaml:"action_concurrency"`
 }
 
 type ProcessGroup struct {
 	Name      string
 	Events    map[string]*Event
 	Processes map[string]*Process
+	Rolling   *Rolling
 }
 
 type Event struct {
@@ -53,21 +61,45 @@ type Action struct {
 }
 
 type Process struct {
-	Name        string
-	Pidfile     string
-	Start       string
-	Stop        string
-	Restart     string
-	Gid         string
-	Uid         string
-	Stdout      string
-	Stderr      string
-	Env         []string
-	Dir         string
-	Description string
-	DependsOn   []string
-	Actions     map[string][]string
-	MonitorMode string
+	Name            string
+	Pidfile         string
+	Start           string
+	Stop            string
+	Restart         string
+	Gid             string
+	Uid             string
+	Stdout          string
+	Stderr          string
+	Env             []string
+	EnvFile         string      `yaml:"env_file"`
+	InheritEnv      interface{} `yaml:"inherit_env"`
+	Dir             string
+	Description     string
+	DependsOn       []string
+	Actions         map[string][]string
+	MonitorMode     string
+	Instances       *int
+	StartTimeout    string `yaml:"start_timeout"`
+	StopTimeout     string `yaml:"stop_timeout"`
+	RestartTimeout  string `yaml:"restart_timeout"`
+	StopSignal      string `yaml:"stop_signal"`
+	StopGracePeriod string `yaml:"stop_grace_period"`
+	Supervise       bool
+	Shell           bool
+	Limits          *Limits
+	Nice            int
+	Affinity        []int
+	Cgroup          *Cgroup
+	Output          *Output
+	Hooks           *Hooks
+	Ready           []*Probe
+	Restarts        *RestartPolicy
+	Umask           string
+	Chroot          string
+	groupName       string
+	cgroupRoot      string
+	child           *supervisedChild
+	control         *Control
 }
 
 const (
@@ -81,8 +113,13 @@ const (
 
 const (
 	DEFAULT_ALERT_TRANSPORT = "none"
+	DEFAULT_START_TIMEOUT   = "30s"
+	DEFAULT_STOP_TIMEOUT    = "30s"
 )
 
+// How many processes an action starts or stops at once, unless configured.
+const DEFAULT_ACTION_CONCURRENCY = 8
+
 // Given an action string name, returns the events associated with it.
 func (pg *ProcessGroup) EventByName(eventName string) *Event {
 	event, hasKey := pg.Events[eventName]
@@ -92,6 +129,20 @@ func (pg *ProcessGroup) EventByName(eventName string) *Event {
 	return nil
 }
 
+// Returns the name of the group the process belongs to.
+func (p *Process) GroupName() string {
+	return p.groupName
+}
+
+// Returns "group/process", which identifies a process even when processes in
+// other groups have the same name.
+func (p *Process) FullName() string {
+	if p.groupName == "" {
+		return p.Name
+	}
+	return p.groupName + "/" + p.Name
+}
+
 // Given a process name, returns the Process and whether it exists.
 func (pg *ProcessGroup) processFromName(name string) (*Process, bool) {
 	serv, hasKey := pg.Processes[name]
@@ -105,6 +156,7 @@ func (c *ConfigManager) fillInNames() {
 		processGroup.Name = groupName
 		for name, process := range processGroup.Processes {
 			process.Name = name
+			process.groupName = groupName
 			processGroup.Processes[name] = process
 		}
 		for name, event := range processGroup.Events {
@@ -115,28 +167,20 @@ func (c *ConfigManager) fillInNames() {
 	}
 }
 
-// Parses a config file into a ProcessGroup.
+// Parses a config file and its includes into a ProcessGroup.
 func (c *ConfigManager) parseConfigFile(path string) (*ProcessGroup, error) {
 	processGroup := &ProcessGroup{}
-	b, err := ioutil.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-	if err := goyaml.Unmarshal(b, processGroup); err != nil {
+	if err := c.loadYaml(path, processGroup); err != nil {
 		return nil, err
 	}
 	Log.Infof("Loaded config file '%+v'", path)
 	return processGroup, nil
 }
 
-// Parses a settings file into a Settings struct.
+// Parses a settings file and its includes into a Settings struct.
 func (c *ConfigManager) parseSettingsFile(path string) (*Settings, error) {
 	settings := &Settings{}
-	b, err := ioutil.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-	if err := goyaml.Unmarshal(b, settings); err != nil {
+	if err := c.loadYaml(path, settings); err != nil {
 		return nil, err
 	}
 	Log.Infof("Loaded settings file: '%+v'", path)
@@ -181,6 +225,21 @@ func (settings *Settings) ApplyDefaults() {
 	if settings.AlertTransport == "" {
 		settings.AlertTransport = DEFAULT_ALERT_TRANSPORT
 	}
+	if settings.UnknownKeys == "" {
+		settings.UnknownKeys = UNKNOWN_KEYS_ERROR
+	}
+	if settings.StartTimeout == "" {
+		settings.StartTimeout = DEFAULT_START_TIMEOUT
+	}
+	if settings.StopTimeout == "" {
+		settings.StopTimeout = DEFAULT_STOP_TIMEOUT
+	}
+	if settings.CgroupRoot == "" {
+		settings.CgroupRoot = DEFAULT_CGROUP_ROOT
+	}
+	if settings.ActionConcurrency == 0 {
+		settings.ActionConcurrency = DEFAULT_ACTION_CONCURRENCY
+	}
 	if settings.Logging == nil {
 		settings.Logging = &LoggerConfig{}
 	}
@@ -244,6 +303,21 @@ func (c *ConfigManager) parseFile(path string) error {
 
 // Main function to call, parses a path for gonit config file(s).
 func (c *ConfigManager) LoadConfig(path string) error {
+	if err := c.parse(path); err != nil {
+		return err
+	}
+	if err := c.checkSchemaErrors(); err != nil {
+		return err
+	}
+	c.applyDefaultConfigOpts()
+	if err := c.validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Parses a path for gonit config file(s) and applies default settings.
+func (c *ConfigManager) parse(path string) error {
 	c.path = path
 	if path == "" {
 		return fmt.Errorf("No config given.")
@@ -251,6 +325,7 @@ func (c *ConfigManager) LoadConfig(path string) error {
 
 	c.ProcessGroups = map[string]*ProcessGroup{}
 	c.Settings = &Settings{}
+	c.schemaErrors = nil
 	fileInfo, err := os.Stat(path)
 	if err != nil {
 		return fmt.Errorf("Error stating path '%+v'.", path)
@@ -265,17 +340,32 @@ func (c *ConfigManager) LoadConfig(path string) error {
 		}
 	}
 	c.fillInNames()
+	if err := c.expandInstances(); err != nil {
+		return err
+	}
 	if (*c.Settings == Settings{}) {
 		Log.Info("No settings found, using defaults")
 	}
 	c.ApplyDefaultSettings()
-	c.applyDefaultConfigOpts()
-	if err := c.validate(); err != nil {
-		return err
-	}
 	return nil
 }
 
+// Fails on keys that don't belong in the config, or only logs them if the
+// settings ask for that.
+func (c *ConfigManager) checkSchemaErrors() error {
+	switch c.Settings.UnknownKeys {
+	case UNKNOWN_KEYS_ERROR:
+		return c.schemaErrors.errOrNil()
+	case UNKNOWN_KEYS_WARN:
+		for _, err := range c.schemaErrors {
+			Log.Warn(err.Error())
+		}
+		return nil
+	}
+	return fmt.Errorf("Settings unknownkeys must be '%v' or '%v', not '%v'.",
+		UNKNOWN_KEYS_ERROR, UNKNOWN_KEYS_WARN, c.Settings.UnknownKeys)
+}
+
 func (c *ConfigManager) applyDefaultMonitorMode() {
 	for _, pg := range c.ProcessGroups {
 		for _, process := range pg.Processes {
@@ -286,72 +376,312 @@ func (c *ConfigManager) applyDefaultMonitorMode() {
 	}
 }
 
+// Processes without their own timeouts get the ones from the settings.  The
+// restart timeout falls back to the start timeout.
+func (c *ConfigManager) applyDefaultTimeouts() {
+	for _, pg := range c.ProcessGroups {
+		for _, process := range pg.Processes {
+			if process.StartTimeout == "" {
+				process.StartTimeout = c.Settings.StartTimeout
+			}
+			if process.StopTimeout == "" {
+				process.StopTimeout = c.Settings.StopTimeout
+			}
+			if process.RestartTimeout == "" {
+				process.RestartTimeout = c.Settings.RestartTimeout
+			}
+			if process.RestartTimeout == "" {
+				process.RestartTimeout = process.StartTimeout
+			}
+		}
+	}
+}
+
+// Processes with a cgroup get theirs under the cgroup root of the settings.
+func (c *ConfigManager) applyCgroupRoot() {
+	for _, pg := range c.ProcessGroups {
+		for _, process := range pg.Processes {
+			process.cgroupRoot = c.Settings.CgroupRoot
+		}
+	}
+}
+
 func (c *ConfigManager) applyDefaultConfigOpts() {
 	c.applyDefaultMonitorMode()
+	c.applyDefaultTimeouts()
+	c.applyCgroupRoot()
+}
+
+// Returns the process groups sorted by name.
+func (c *ConfigManager) sortedGroups() []*ProcessGroup {
+	names := []string{}
+	for name := range c.ProcessGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	groups := make([]*ProcessGroup, len(names))
+	for i, name := range names {
+		groups[i] = c.ProcessGroups[name]
+	}
+	return groups
+}
+
+// Returns the group's processes sorted by name.
+func (pg *ProcessGroup) sortedProcesses() []*Process {
+	names := []string{}
+	for name := range pg.Processes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	processes := make([]*Process, len(names))
+	for i, name := range names {
+		processes[i] = pg.Processes[name]
+	}
+	return processes
+}
+
+// Returns the group's event names sorted.
+func (pg *ProcessGroup) sortedEventNames() []string {
+	names := []string{}
+	for name := range pg.Events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the start, stop and restart programs, the hooks and the probe
+// commands of a process by config key, leaving out the ones that aren't set.
+func (p *Process) commands() [][2]string {
+	commands := [][2]string{}
+	for _, command := range [][2]string{
+		{"start", p.Start}, {"stop", p.Stop}, {"restart", p.Restart}} {
+		if command[1] != "" {
+			commands = append(commands, command)
+		}
+	}
+	names, hooks := p.allHooks()
+	for i, hook := range hooks {
+		if hook != nil && hook.Command != "" {
+			commands = append(commands, [2]string{names[i], hook.Command})
+		}
+	}
+	for i, probe := range p.Ready {
+		if probe != nil && probe.Command != "" {
+			commands = append(commands,
+				[2]string{fmt.Sprintf("ready probe %v", i+1), probe.Command})
+		}
+	}
+	return commands
+}
+
+// Checks that the programs of a process can be split into words.
+func (p *Process) validateCommands() error {
+	errs := ConfigErrors{}
+	for _, command := range p.commands() {
+		if _, err := p.commandArgs(command[1]); err != nil {
+			errs.add(fmt.Errorf("Process %v %v: %v", p.FullName(), command[0],
+				err))
+		}
+	}
+	return errs.errOrNil()
+}
+
+// Returns the names of the actions of a process in sorted order.
+func (p *Process) sortedActionNames() []string {
+	names := []string{}
+	for name := range p.Actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // Validates that certain fields exist in the config file.
 func (pg ProcessGroup) validateRequiredFieldsExist() error {
-	for name, process := range pg.Processes {
+	errs := ConfigErrors{}
+	for _, process := range pg.sortedProcesses() {
+		if strings.Contains(process.Name, "/") {
+			errs.add(fmt.Errorf("Process name '%v' must not contain '/'.",
+				process.Name))
+		}
 		if process.Name == "" || process.Description == "" ||
-			process.Pidfile == "" || process.Start == "" {
-			return fmt.Errorf("%v must have name, description, pidfile and start.",
-				name)
+			(process.Pidfile == "" && !process.Supervise) ||
+			process.Start == "" {
+			errs.add(fmt.Errorf("%v must have name, description, pidfile and "+
+				"start.", pg.processKey(process)))
 		}
 	}
-	for name, event := range pg.Events {
+	for _, name := range pg.sortedEventNames() {
+		event := pg.Events[name]
 		if event.Name == "" || event.Description == "" || event.Rule == "" {
-			return fmt.Errorf("%v must have name, description, rule, and "+
-				"actions.", name)
+			errs.add(fmt.Errorf("%v must have name, description, rule, and "+
+				"actions.", name))
 		}
 	}
-	return nil
+	return errs.errOrNil()
+}
+
+// Returns the key a process is stored under in the group.
+func (pg *ProcessGroup) processKey(process *Process) string {
+	for name, p := range pg.Processes {
+		if p == process {
+			return name
+		}
+	}
+	return process.Name
 }
 
-// Validates various links in a config.
+// Validates the event rules of a group and the links from process actions to
+// them, by loading them into an EventMonitor the same way the daemon does.
+// Events that no action uses are only logged.
 func (pg *ProcessGroup) validateLinks() error {
-	// TODO: Validate the event links.
-	for _, process := range pg.Processes {
-		for _, dependsOnName := range process.DependsOn {
-			if _, hasKey := pg.processFromName(dependsOnName); hasKey == false {
-				return fmt.Errorf("Process %v has an unknown dependson '%v'.",
-					process.Name, dependsOnName)
+	errs := ConfigErrors{}
+	eventMonitor := &EventMonitor{resourceManager: resourceManager}
+	invalidEvents := map[string]bool{}
+	for _, name := range pg.sortedEventNames() {
+		if err := eventMonitor.validateEvent(pg.Events[name]); err != nil {
+			errs.add(fmt.Errorf("Event %v: %v", name, err))
+			invalidEvents[name] = true
+		}
+	}
+
+	usedEvents := map[string]bool{}
+	for _, process := range pg.sortedProcesses() {
+		for _, actionName := range process.sortedActionNames() {
+			if !isValidAction(actionName) {
+				errs.add(fmt.Errorf("Process %v has an invalid action '%v'.  "+
+					"Valid actions are [%v].", process.FullName(), actionName,
+					strings.Join(validActions, ", ")))
+				continue
+			}
+			for _, eventName := range process.Actions[actionName] {
+				usedEvents[eventName] = true
+				event := pg.EventByName(eventName)
+				if event == nil {
+					errs.add(fmt.Errorf("Process %v has an unknown event '%v' on "+
+						"action '%v'.", process.FullName(), eventName, actionName))
+					continue
+				}
+				if invalidEvents[eventName] {
+					continue
+				}
+				err := eventMonitor.loadEvent(event, pg.Name, process, actionName)
+				if err != nil {
+					errs.add(fmt.Errorf("Process %v event '%v' on action '%v': %v",
+						process.FullName(), eventName, actionName, err))
+				}
 			}
 		}
 	}
 
-	return nil
+	for _, name := range pg.sortedEventNames() {
+		if !usedEvents[name] {
+			Log.Warnf("Event '%v' in group '%v' is not used by any process "+
+				"action.", name, pg.Name)
+		}
+	}
+	return errs.errOrNil()
 }
 
 // Valitades settings.
 func (s *Settings) validate() error {
+	errs := ConfigErrors{}
 	if s.AlertTransport == UNIX_SOCKET_TRANSPORT && s.SocketFile == "" {
-		return fmt.Errorf("Settings uses '%v' alerts transport, but has no socket"+
-			" file.", UNIX_SOCKET_TRANSPORT)
+		errs.add(fmt.Errorf("Settings uses '%v' alerts transport, but has no "+
+			"socket file.", UNIX_SOCKET_TRANSPORT))
+	}
+	if s.ProcessPollInterval < 0 {
+		errs.add(fmt.Errorf("Settings processpollinterval must not be " +
+			"negative."))
+	}
+	errs.add(validateTimeout("Settings start_timeout", s.StartTimeout))
+	errs.add(validateTimeout("Settings stop_timeout", s.StopTimeout))
+	errs.add(validateTimeout("Settings restart_timeout", s.RestartTimeout))
+	if s.ActionConcurrency < 0 {
+		errs.add(fmt.Errorf("Settings action_concurrency must not be " +
+			"negative."))
+	}
+	errs.add(s.validatePersistFile())
+	return errs.errOrNil()
+}
+
+// Checks that a timeout, if set, is a positive duration such as "90s".
+func validateTimeout(what string, timeout string) error {
+	if timeout == "" {
+		return nil
 	}
-	if err := s.validatePersistFile(); err != nil {
-		return err
+	duration, err := time.ParseDuration(timeout)
+	if err != nil {
+		return fmt.Errorf("%v '%v' is not a duration.", what, timeout)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("%v must be positive, not '%v'.", what, timeout)
 	}
 	return nil
 }
 
-// Validates a process group config.
+// Checks that the configured user and group exist.
+func (p *Process) validateCredentials() error {
+	errs := ConfigErrors{}
+	if p.Uid != "" {
+		if _, err := LookupUser(p.Uid); err != nil {
+			errs.add(fmt.Errorf("Process %v has an unknown uid '%v'.", p.FullName(),
+				p.Uid))
+		}
+	}
+	if p.Gid != "" {
+		if _, err := LookupGroupId(p.Gid); err != nil {
+			errs.add(fmt.Errorf("Process %v has an unknown gid '%v'.", p.FullName(),
+				p.Gid))
+		}
+	}
+	return errs.errOrNil()
+}
+
+// Validates the timeouts and stop signal of a process.
+func (p *Process) validateTimeouts() error {
+	errs := ConfigErrors{}
+	name := "Process " + p.FullName()
+	errs.add(validateTimeout(name+" start_timeout", p.StartTimeout))
+	errs.add(validateTimeout(name+" stop_timeout", p.StopTimeout))
+	errs.add(validateTimeout(name+" restart_timeout", p.RestartTimeout))
+	errs.add(validateTimeout(name+" stop_grace_period", p.StopGracePeriod))
+	if p.StopSignal != "" {
+		if _, err := parseSignal(p.StopSignal); err != nil {
+			errs.add(fmt.Errorf("%v stop_signal: %v", name, err))
+		}
+	}
+	return errs.errOrNil()
+}
+
+// Validates a process group config.  All problems found are returned as
+// ConfigErrors.
 func (c *ConfigManager) validate() error {
 	if len(c.ProcessGroups) == 0 {
 		return fmt.Errorf("A configuration file (*-gonit.yml) must be provided.")
 	}
-	for _, pg := range c.ProcessGroups {
-		if err := pg.validateRequiredFieldsExist(); err != nil {
-			return err
-		}
-		if err := pg.validateLinks(); err != nil {
-			return err
+	errs := ConfigErrors{}
+	for _, pg := range c.sortedGroups() {
+		errs.add(pg.validateRequiredFieldsExist())
+		errs.add(pg.validateLinks())
+		errs.add(pg.validateRolling())
+		for _, process := range pg.sortedProcesses() {
+			errs.add(process.validateTimeouts())
+			errs.add(process.validateCredentials())
+			errs.add(process.validateEnv())
+			errs.add(process.validateCommands())
+			errs.add(process.validateExecutables())
+			errs.add(process.validateHooks())
+			errs.add(process.validateProbes())
+			errs.add(process.validateSpawnSettings())
+			errs.add(process.validateOutput())
+			errs.add(process.validateRestarts())
 		}
 	}
-	if err := c.Settings.validate(); err != nil {
-		return err
-	}
-	return nil
+	errs.add(c.validateDependencies())
+	errs.add(c.Settings.validate())
+	return errs.errOrNil()
 }
 
 func (p *Process) IsMonitoringModeActive() bool {
@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	. "launchpad.net/gocheck"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 type ConfigSuite struct{}
@@ -26,6 +28,8 @@ func assertFileParsed(c *C, configManager *ConfigManager) {
 	c.Check(2, Equals, len(opentsdb.Actions["alert"]))
 	c.Check(1, Equals, len(opentsdb.Actions["restart"]))
 	c.Check(1, Equals, len(dashboard.Actions["alert"]))
+	c.Check([]string{"opentsdb"}, DeepEquals, dashboard.DependsOn)
+	c.Check("nobody", Equals, dashboard.Uid)
 	c.Check("memory_used > 5mb", Equals, pg.EventByName("memory_over_5").Rule)
 	c.Check((*Event)(nil), Equals, pg.EventByName("blah"))
 
@@ -66,6 +70,22 @@ func (s *ConfigSuite) TestParseDir(c *C) {
 	assertFileParsed(c, configManager)
 }
 
+func (s *ConfigSuite) TestMissingExecutable(c *C) {
+	path := filepath.Join(c.MkDir(), "web-gonit.yml")
+	err := ioutil.WriteFile(path, []byte(`---
+processes:
+  web:
+    description: web server
+    pidfile: /tmp/web.pid
+    start: /var/vcap/jobs/web/bin/web_ctl start
+`), 0644)
+	c.Assert(err, IsNil)
+
+	configManager := &ConfigManager{}
+	c.Check(configManager.LoadConfig(path), ErrorMatches, "Process web/web "+
+		"start program '/var/vcap/jobs/web/bin/web_ctl' not found: .*")
+}
+
 func (s *ConfigSuite) TestNoSettingsLoadsDefaults(c *C) {
 	configManager := &ConfigManager{}
 	err := configManager.LoadConfig("test/config/dashboard-gonit.yml")
@@ -142,3 +162,130 @@ func (s *ConfigSuite) TestValidatePersistGood(c *C) {
 	err := settings.validatePersistFile()
 	c.Check(err, IsNil)
 }
+
+func (s *ConfigSuite) TestFindProcess(c *C) {
+	configManager := &ConfigManager{ProcessGroups: map[string]*ProcessGroup{}}
+	web := &Process{Name: "worker"}
+	batch := &Process{Name: "worker"}
+	db := &Process{Name: "db"}
+	c.Check(configManager.AddProcess("web", web), IsNil)
+	c.Check(configManager.AddProcess("batch", batch), IsNil)
+	c.Check(configManager.AddProcess("web", db), IsNil)
+
+	c.Check("web/worker", Equals, web.FullName())
+	c.Check("web", Equals, web.GroupName())
+
+	process, err := configManager.FindProcess("db")
+	c.Check(err, IsNil)
+	c.Check(process, Equals, db)
+
+	process, err = configManager.FindProcess("web/db")
+	c.Check(err, IsNil)
+	c.Check(process, Equals, db)
+
+	process, err = configManager.FindProcess("batch/worker")
+	c.Check(err, IsNil)
+	c.Check(process, Equals, batch)
+
+	_, err = configManager.FindProcess("worker")
+	c.Check(err, ErrorMatches, `process "worker" is ambiguous, use one of: `+
+		`batch/worker, web/worker`)
+
+	_, err = configManager.FindProcess("batch/db")
+	c.Check(err, ErrorMatches, `process "batch/db" not found`)
+}
+
+func (s *ConfigSuite) TestTimeouts(c *C) {
+	configManager := &ConfigManager{
+		Settings: &Settings{StopTimeout: "1m"},
+		ProcessGroups: map[string]*ProcessGroup{
+			"jvm": &ProcessGroup{
+				Processes: map[string]*Process{
+					"slow": &Process{StartTimeout: "3m"},
+					"fast": &Process{StopTimeout: "5s"},
+				},
+			},
+		},
+	}
+	configManager.fillInNames()
+	configManager.ApplyDefaultSettings()
+	configManager.applyDefaultConfigOpts()
+
+	slow := configManager.ProcessGroups["jvm"].Processes["slow"]
+	c.Check(slow.startTimeout(), Equals, 3*time.Minute)
+	c.Check(slow.stopTimeout(), Equals, time.Minute)
+	c.Check(slow.restartTimeout(), Equals, 3*time.Minute)
+	fast := configManager.ProcessGroups["jvm"].Processes["fast"]
+	c.Check(fast.startTimeout(), Equals, 30*time.Second)
+	c.Check(fast.stopTimeout(), Equals, 5*time.Second)
+
+	c.Check(validateTimeout("start_timeout", "3 minutes"), ErrorMatches,
+		"start_timeout '3 minutes' is not a duration.")
+	c.Check(validateTimeout("stop_timeout", "0s"), ErrorMatches,
+		"stop_timeout must be positive, not '0s'.")
+}
+
+func (s *ConfigSuite) TestValidateHooks(c *C) {
+	process := &Process{
+		Name: "web",
+		Hooks: &Hooks{
+			PreStart: []*Hook{{Command: "mkdir -p /var/run/web"},
+				{Command: "migrate", Timeout: "soon"}},
+			PostStop: []*Hook{{Command: "deregister", Uid: "gonit_no_such_user"},
+				{}},
+		},
+	}
+	err := process.validateHooks()
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Process web pre_start hook 2 timeout "+
+		"'soon' is not a duration.\n"+
+		"Process web post_stop hook 1 has an unknown uid "+
+		"'gonit_no_such_user'.\n"+
+		"Process web post_stop hook 2 has no command.")
+
+	c.Check(process.commands(), DeepEquals, [][2]string{
+		{"pre_start hook 1", "mkdir -p /var/run/web"},
+		{"pre_start hook 2", "migrate"},
+		{"post_stop hook 1", "deregister"},
+	})
+}
+
+func (s *ConfigSuite) TestHookProcess(c *C) {
+	process := &Process{
+		Name:   "web",
+		Uid:    "vcap",
+		Gid:    "vcap",
+		Env:    []string{"PORT=8080"},
+		Cgroup: &Cgroup{},
+		Output: &Output{},
+		Limits: &Limits{},
+		Nice:   5,
+	}
+	hook := (&Hook{Command: "chgrp adm /var/run/web", Gid: "adm"}).
+		process(process)
+	c.Check(hook.Uid, Equals, "vcap")
+	c.Check(hook.Gid, Equals, "adm")
+	c.Check(hook.Env, DeepEquals, process.Env)
+	c.Check(hook.Cgroup, IsNil)
+	c.Check(hook.Output, IsNil)
+	c.Check(hook.Limits, IsNil)
+	c.Check(hook.Nice, Equals, 0)
+
+	hook = (&Hook{Command: "register", Uid: "root"}).process(process)
+	c.Check(hook.Uid, Equals, "root")
+	c.Check(hook.Gid, Equals, "vcap")
+}
+
+func (s *ConfigSuite) TestValidateRolling(c *C) {
+	group := &ProcessGroup{Name: "workers"}
+	c.Check(group.validateRolling(), IsNil)
+
+	group.Rolling = &Rolling{Batch: -1, Pause: "later"}
+	err := group.validateRolling()
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Group workers rolling batch must not be "+
+		"negative.\nGroup workers rolling pause 'later' is not a duration.")
+
+	group.Rolling = &Rolling{Batch: 2, Pause: "10s"}
+	c.Check(group.validateRolling(), IsNil)
+}
@@ -7,7 +7,11 @@ import (
 	"io/ioutil"
 	"launchpad.net/goyaml"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -40,10 +44,15 @@ const (
 	ERROR_IN_PROGRESS_FMT = "Process %q action already in progress"
 )
 
+// How long to wait for a process to exit after SIGKILL.
+const killTimeout = 5 * time.Second
+
 // So we can mock it in tests.
 type EventMonitorInterface interface {
 	StartMonitoringProcess(process *Process)
+	CleanDataForProcess(process *Process)
 	Start(configManager *ConfigManager, control *Control) error
+	Reload(configManager *ConfigManager, control *Control) error
 	Stop()
 }
 
@@ -51,13 +60,78 @@ type Control struct {
 	ConfigManager *ConfigManager
 	EventMonitor  EventMonitorInterface
 	States        map[string]*ProcessState
+	statesLock    sync.Mutex
 	persistLock   sync.Mutex
+	jobs          jobTable
+	// Held for reading while the config is in use by a job, a watcher check,
+	// an event monitor poll or a status call, and for writing while Reload
+	// replaces it.  Only taken where those start, never by what they call.
+	configLock sync.RWMutex
+	// Full names of supervised processes that have exited, for the Watcher.
+	supervisedExits chan string
+	exitsOnce       sync.Once
+}
+
+// Returns the channel supervised processes started by Control report their
+// exits on.
+func (c *Control) exits() chan string {
+	c.exitsOnce.Do(func() {
+		c.supervisedExits = make(chan string, 64)
+	})
+	return c.supervisedExits
 }
 
+// Processes are started as soon as everything they depend on has started,
+// and stopped as soon as everything that depends on them has stopped, so
+// independent processes are started and stopped at the same time.  At most
+// 'action_concurrency' processes are started or stopped at once by an action.
+
 type ControlAction struct {
+	sync.Mutex
 	scope  int
 	method int
-	visits map[string]*visitor
+	visits *actionVisits
+	errors []error
+	steps  []ActionStep
+}
+
+// Something done to a process while carrying out an action.
+type ActionStep struct {
+	Process string
+	Message string
+}
+
+// Returned when a process does not reach the expected state in time.
+type TimeoutError struct {
+	Process string
+	Action  string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("process %q did not %v within %v", e.Process, e.Action,
+		e.Timeout)
+}
+
+// Records an error from one of the processes visited by the action.
+func (c *ControlAction) fail(err error) {
+	c.Lock()
+	defer c.Unlock()
+	c.errors = append(c.errors, err)
+}
+
+// Logs and records a step taken on a process.
+func (c *ControlAction) step(process *Process, format string,
+	args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	Log.Infof("process %q: %v", process.FullName(), message)
+	step := ActionStep{process.FullName(), message}
+	c.Lock()
+	c.steps = append(c.steps, step)
+	c.Unlock()
+	if c.visits.onStep != nil {
+		c.visits.onStep(step)
+	}
 }
 
 // flags to avoid invoking actions more than once
@@ -65,6 +139,31 @@ type ControlAction struct {
 type visitor struct {
 	started bool
 	stopped bool
+	// closed once the process has been started or stopped
+	startDone chan bool
+	stopDone  chan bool
+	startOk   bool
+	stopOk    bool
+}
+
+// The visits of an action and of the actions forked from it.
+type actionVisits struct {
+	sync.Mutex
+	visitors map[string]*visitor
+	// one is taken while a process is being started or stopped
+	slots chan bool
+	// set when the action runs as a job
+	jobId     int
+	onStep    func(step ActionStep)
+	cancelled bool
+	// set when the action was asked for through the API, which starts a
+	// failed process again
+	byHand bool
+	// set when the action was triggered by an event rule, which is held
+	// back by the restart policy of the process as the watcher is
+	byRule bool
+	// the visits of the action this one is a batch of, if any
+	parent *actionVisits
 }
 
 // XXX TODO should state be attached to Process type?
@@ -72,9 +171,32 @@ type ProcessState struct {
 	Monitor     int
 	MonitorLock sync.Mutex
 	Starts      int
+	Identity    *ProcessIdentity
+	LastExit    *ExitStatus `yaml:"last_exit"`
+	LastCommand *ExitStatus `yaml:"last_command"`
+	Restart     *RestartState
 
 	actionPending     bool
+	pendingJob        int
 	actionPendingLock sync.Mutex
+	// whether Identity changed since the states were last persisted
+	identityChanged bool
+	// the pid of the last stale pidfile warned about
+	stalePid int
+	// the OOM kills counted in the cgroup of the process when last checked
+	oomKills    uint64
+	hasOomKills bool
+}
+
+// Takes over what was persisted of a state.
+func (s *ProcessState) restore(persisted *ProcessState) {
+	s.MonitorLock.Lock()
+	s.Monitor = persisted.Monitor
+	s.MonitorLock.Unlock()
+	s.Starts = persisted.Starts
+	s.Identity = persisted.Identity
+	s.LastExit, s.LastCommand = persisted.LastExit, persisted.LastCommand
+	s.Restart = persisted.Restart
 }
 
 // XXX TODO needed for tests, a form of this should probably be in ConfigManager
@@ -93,23 +215,57 @@ func (c *ConfigManager) AddProcess(groupName string, process *Process) error {
 	if _, exists := group.Processes[process.Name]; exists {
 		return fmt.Errorf("process %q already exists", process.Name)
 	} else {
+		process.groupName = groupName
 		group.Processes[process.Name] = process
 	}
 
 	return nil
 }
 
-// BUG(lisbakke): If there are two processes named the same thing in different process groups, this could return the wrong process. ConfigManager should enforce unique group/process names.
 // XXX TODO should probably be in configmanager.go
-// Helper methods to find a Process by name
+// Helper methods to find a Process by name.
+// The name is either "group/process", or a bare process name that only
+// exists in one group.
 func (c *ConfigManager) FindProcess(name string) (*Process, error) {
-	for _, processGroup := range c.ProcessGroups {
+	if slash := strings.Index(name, "/"); slash >= 0 {
+		group, exists := c.ProcessGroups[name[:slash]]
+		if exists {
+			if process, exists := group.Processes[name[slash+1:]]; exists {
+				return process, nil
+			}
+		}
+		return nil, fmt.Errorf("process %q not found", name)
+	}
+
+	var found []*Process
+	for _, processGroup := range c.sortedGroups() {
 		if process, exists := processGroup.Processes[name]; exists {
-			return process, nil
+			found = append(found, process)
 		}
 	}
 
-	return nil, fmt.Errorf("process %q not found", name)
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("process %q not found", name)
+	case 1:
+		return found[0], nil
+	}
+	names := make([]string, len(found))
+	for i, process := range found {
+		names[i] = process.FullName()
+	}
+	return nil, fmt.Errorf("process %q is ambiguous, use one of: %s", name,
+		strings.Join(names, ", "))
+}
+
+// Helper method to find a process that the given process depends on.
+// Bare names refer to a process in the same group.
+func (c *ConfigManager) FindDependency(process *Process,
+	name string) (*Process, error) {
+	if process.groupName != "" && !strings.Contains(name, "/") {
+		name = process.groupName + "/" + name
+	}
+	return c.FindProcess(name)
 }
 
 // TODO should probably be in configmanager.go
@@ -148,19 +304,186 @@ func (c *ConfigManager) VisitProcesses(visit func(p *Process) bool) {
 	}
 }
 
+// must be called with c.visits locked
 func (c *ControlAction) visitorOf(process *Process) *visitor {
-	if _, exists := c.visits[process.Name]; !exists {
-		c.visits[process.Name] = &visitor{}
+	name := process.FullName()
+	visitors := c.visits.visitors
+	if _, exists := visitors[name]; !exists {
+		visitors[name] = &visitor{}
+	}
+
+	return visitors[name]
+}
+
+// Claims the start of a process, once any stop of it by the action is done.
+// Returns false if the start was already claimed, once it is done, along
+// with whether it succeeded.
+func (c *ControlAction) claimStart(process *Process) (bool, bool) {
+	c.visits.Lock()
+	visitor := c.visitorOf(process)
+	if visitor.started {
+		startDone := visitor.startDone
+		c.visits.Unlock()
+		<-startDone
+		c.visits.Lock()
+		defer c.visits.Unlock()
+		return false, visitor.startOk
+	}
+	visitor.started = true
+	visitor.startDone = make(chan bool)
+	stopDone := visitor.stopDone
+	c.visits.Unlock()
+
+	if stopDone != nil {
+		<-stopDone
+	}
+	return true, false
+}
+
+func (c *ControlAction) finishStart(process *Process, ok bool) {
+	c.visits.Lock()
+	defer c.visits.Unlock()
+	visitor := c.visitorOf(process)
+	visitor.startOk = ok
+	close(visitor.startDone)
+}
+
+// Claims the stop of a process.  Returns false if the stop was already
+// claimed, once it is done, along with whether it succeeded.  Processes the
+// action has started are not stopped by it.
+func (c *ControlAction) claimStop(process *Process) (bool, bool) {
+	c.visits.Lock()
+	visitor := c.visitorOf(process)
+	if visitor.started {
+		c.visits.Unlock()
+		return false, true
+	}
+	if visitor.stopped {
+		stopDone := visitor.stopDone
+		c.visits.Unlock()
+		<-stopDone
+		c.visits.Lock()
+		defer c.visits.Unlock()
+		return false, visitor.stopOk
+	}
+	visitor.stopped = true
+	visitor.stopDone = make(chan bool)
+	c.visits.Unlock()
+	return true, false
+}
+
+func (c *ControlAction) finishStop(process *Process, ok bool) {
+	c.visits.Lock()
+	defer c.visits.Unlock()
+	visitor := c.visitorOf(process)
+	visitor.stopOk = ok
+	close(visitor.stopDone)
+}
+
+// Returns whether the action has claimed the start of a process.
+func (c *ControlAction) isStarted(process *Process) bool {
+	c.visits.Lock()
+	defer c.visits.Unlock()
+	return c.visitorOf(process).started
+}
+
+// Stops the action from starting or stopping any more processes.
+func (c *ControlAction) cancel() {
+	c.visits.Lock()
+	defer c.visits.Unlock()
+	c.visits.cancelled = true
+}
+
+func (c *ControlAction) isCancelled() bool {
+	c.visits.Lock()
+	cancelled, parent := c.visits.cancelled, c.visits.parent
+	c.visits.Unlock()
+	if !cancelled && parent != nil {
+		parent.Lock()
+		defer parent.Unlock()
+		cancelled = parent.cancelled
 	}
+	return cancelled
+}
 
-	return c.visits[process.Name]
+// Returns an action that shares the visits of c, but records its own errors
+// and steps.
+func (c *ControlAction) fork() *ControlAction {
+	return &ControlAction{scope: c.scope, method: c.method, visits: c.visits}
+}
+
+// Returns an action with visits of its own, so that it visits processes c
+// already visited again, but that is part of the same job as c and is
+// cancelled along with it.
+func (c *ControlAction) batch() *ControlAction {
+	action := NewControlAction(c.method)
+	action.scope = c.scope
+	action.visits.jobId = c.visits.jobId
+	action.visits.onStep = c.visits.onStep
+	action.visits.byHand = c.visits.byHand
+	action.visits.parent = c.visits
+	return action
+}
+
+// Takes one of the slots of the action, waiting until one is free.  Returns
+// the slots, to give it back to.
+func (c *Control) takeSlot(action *ControlAction) chan bool {
+	action.visits.Lock()
+	if action.visits.slots == nil {
+		action.visits.slots = make(chan bool, c.concurrency())
+	}
+	slots := action.visits.slots
+	action.visits.Unlock()
+	slots <- true
+	return slots
+}
+
+// How many processes an action may start or stop at once.
+func (c *Control) concurrency() int {
+	if c.ConfigManager != nil && c.ConfigManager.Settings != nil &&
+		c.ConfigManager.Settings.ActionConcurrency > 0 {
+		return c.ConfigManager.Settings.ActionConcurrency
+	}
+	return DEFAULT_ACTION_CONCURRENCY
+}
+
+// Visits each process at the same time, and waits until all are visited.
+func visitEach(processes []*Process, visit func(process *Process)) {
+	var wg sync.WaitGroup
+	for _, process := range processes {
+		wg.Add(1)
+		go func(process *Process) {
+			defer wg.Done()
+			visit(process)
+		}(process)
+	}
+	wg.Wait()
 }
 
 func (c *Control) State(process *Process) *ProcessState {
+	c.statesLock.Lock()
+	defer c.statesLock.Unlock()
+	return c.state(process)
+}
+
+// Guards the Control of every process, which is set when Control first
+// looks up its state while the process may be in use elsewhere.
+var processControls sync.Mutex
+
+// Returns the state of the process, creating it if there is none yet.  The
+// states must be locked.
+func (c *Control) state(process *Process) *ProcessState {
+	processControls.Lock()
+	// only written once, as copies are made of processes while in use
+	if process.control != c {
+		process.control = c
+	}
+	processControls.Unlock()
+
 	if c.States == nil {
 		c.States = make(map[string]*ProcessState)
 	}
-	procName := process.Name
+	procName := process.FullName()
 	if _, exists := c.States[procName]; !exists {
 		state := &ProcessState{}
 		c.States[procName] = state
@@ -172,6 +495,25 @@ func (c *Control) State(process *Process) *ProcessState {
 	return c.States[procName]
 }
 
+// Runs f with the state of the process, while the states are locked.
+func (c *Control) withState(process *Process, f func(state *ProcessState)) {
+	c.statesLock.Lock()
+	defer c.statesLock.Unlock()
+	f(c.state(process))
+}
+
+// Runs f with the state of the process, if it is run by a Control.  What
+// gonit records about a process, such as its identity, is kept in its state
+// so that it is persisted and carries over to a reloaded config.
+func (p *Process) withState(f func(state *ProcessState)) {
+	processControls.Lock()
+	c := p.control
+	processControls.Unlock()
+	if c != nil {
+		c.withState(p, f)
+	}
+}
+
 // Registers the event monitor with Control so that it can turn event monitoring
 // on/off when processes are started/stopped.
 func (c *Control) RegisterEventMonitor(eventMonitor *EventMonitor) {
@@ -181,7 +523,7 @@ func (c *Control) RegisterEventMonitor(eventMonitor *EventMonitor) {
 func NewControlAction(method int) *ControlAction {
 	return &ControlAction{
 		method: method,
-		visits: make(map[string]*visitor),
+		visits: &actionVisits{visitors: make(map[string]*visitor)},
 	}
 }
 
@@ -202,23 +544,39 @@ func (c *Control) DoAction(name string, action *ControlAction) error {
 		return err
 	}
 
-	return c.invoke(process, func() error {
+	return c.invoke(process, action.visits.jobId, func() error {
+		if action.method == ACTION_START || action.method == ACTION_RESTART {
+			switch {
+			case action.visits.byHand:
+				// a failed process is restarted by the watcher again once
+				// it has been started by hand
+				process.resetRestarts()
+			case action.visits.byRule:
+				if !c.restartAllowed(process) {
+					action.step(process, "not restarted, held back by its "+
+						"restart policy")
+					return nil
+				}
+			}
+		}
 		return c.dispatchAction(process, action)
 	})
 }
 
 func (c *Control) dispatchAction(process *Process, action *ControlAction) error {
+	nerrors := len(action.errors)
 
 	switch action.method {
 	case ACTION_START:
 		if process.IsRunning() {
-			Log.Debugf("Process %q already running", process.Name)
+			action.step(process, "already running")
 			c.monitorSet(process)
 			return nil
 		}
 		c.doDepend(process, ACTION_STOP, action)
-		c.doStart(process, action)
-		c.doDepend(process, ACTION_START, action)
+		if c.doStart(process, action) {
+			c.doDepend(process, ACTION_START, action)
+		}
 
 	case ACTION_STOP:
 		c.doDepend(process, ACTION_STOP, action)
@@ -227,8 +585,9 @@ func (c *Control) dispatchAction(process *Process, action *ControlAction) error
 	case ACTION_RESTART:
 		c.doDepend(process, ACTION_STOP, action)
 		if c.doStop(process, action) {
-			c.doStart(process, action)
-			c.doDepend(process, ACTION_START, action)
+			if c.doStart(process, action) {
+				c.doDepend(process, ACTION_START, action)
+			}
 		} else {
 			c.monitorSet(process)
 		}
@@ -242,83 +601,215 @@ func (c *Control) dispatchAction(process *Process, action *ControlAction) error
 
 	default:
 		err := fmt.Errorf("process %q -- invalid action: %d",
-			process.Name, action)
+			process.FullName(), action.method)
 		return err
 	}
 	if err := c.PersistStates(c.States); err != nil {
 		Log.Errorf("Error persisting state: '%v'", err.Error())
 	}
+	if len(action.errors) > nerrors {
+		return action.errors[nerrors]
+	}
 	return nil
 }
 
 // do not allow more than one control action per process at the same time
-func (c *Control) invoke(process *Process, action func() error) error {
-	if c.isActionPending(process) {
-		return fmt.Errorf(ERROR_IN_PROGRESS_FMT, process.Name)
+func (c *Control) invoke(process *Process, jobId int,
+	action func() error) error {
+	if pendingJob, claimed := c.claimActionPending(process,
+		jobId); !claimed {
+		if pendingJob != 0 {
+			return fmt.Errorf(ERROR_IN_PROGRESS_FMT+" by job %d",
+				process.FullName(), pendingJob)
+		}
+		return fmt.Errorf(ERROR_IN_PROGRESS_FMT, process.FullName())
 	}
-	c.setActionPending(process, true)
 	defer c.setActionPending(process, false)
 
 	return action()
 }
 
-// Start the given Process dependencies before starting Process
-func (c *Control) doStart(process *Process, action *ControlAction) {
-	visitor := action.visitorOf(process)
-	if visitor.started {
-		return
+// Start the given Process dependencies before starting Process.  Returns
+// whether the process is running, which it is not if any of them failed to
+// start.
+func (c *Control) doStart(process *Process, action *ControlAction) bool {
+	claimed, rv := action.claimStart(process)
+	if !claimed {
+		return rv
 	}
-	visitor.started = true
+	rv = true
 
 	if action.scope != scopeRestartGroup {
+		var parents []*Process
 		for _, d := range process.DependsOn {
-			parent, err := c.Config().FindProcess(d)
+			parent, err := c.Config().FindDependency(process, d)
 			if err != nil {
 				panic(err)
 			}
-			c.doStart(parent, action)
+			parents = append(parents, parent)
+		}
+		var lock sync.Mutex
+		var failed []string
+		visitEach(parents, func(parent *Process) {
+			if !c.doStart(parent, action) {
+				lock.Lock()
+				failed = append(failed, strconv.Quote(parent.FullName()))
+				lock.Unlock()
+			}
+		})
+		if len(failed) != 0 {
+			sort.Strings(failed)
+			action.step(process, "not started, %v failed to start",
+				strings.Join(failed, ", "))
+			action.finishStart(process, false)
+			return false
 		}
 	}
 
-	if !process.IsRunning() {
-		c.State(process).Starts++
-		process.StartProcess()
-		process.waitState(processStarted)
+	slots := c.takeSlot(action)
+	if action.isCancelled() {
+		<-slots
+		action.step(process, "not started, the action was cancelled")
+		action.finishStart(process, false)
+		return false
+	}
+	if process.IsRunning() {
+		action.step(process, "already running")
+	} else {
+		rv = c.start(process, action)
 	}
+	<-slots
 
 	c.monitorSet(process)
+	action.finishStart(process, rv)
+	return rv
+}
+
+// Starts a process that is not running and waits until it is ready.
+// Returns whether it was started.
+func (c *Control) start(process *Process, action *ControlAction) bool {
+	if !c.runHooks(process, HOOK_PRE_START, action) {
+		return false
+	}
+	c.withState(process, func(state *ProcessState) {
+		state.Starts++
+	})
+	timeout := process.startTimeout()
+	if action.method == ACTION_RESTART {
+		timeout = process.restartTimeout()
+	}
+
+	action.step(process, "starting")
+	if _, err := process.startProcess(c.exits()); err != nil {
+		action.step(process, "start failed: %v", err)
+		action.fail(err)
+		return false
+	}
+	if process.waitState(processStarted, timeout) != processStarted {
+		action.step(process, "not started or not ready after %v", timeout)
+		action.fail(&TimeoutError{process.FullName(), "start", timeout})
+		return false
+	}
+	action.step(process, "started")
+	c.runHooks(process, HOOK_POST_START, action)
+	return true
 }
 
 // Stop the given Process.
 // Waits for process to stop or until Process.Timeout is reached.
 func (c *Control) doStop(process *Process, action *ControlAction) bool {
-	visitor := action.visitorOf(process)
-	var rv = true
-	if visitor.stopped {
+	claimed, rv := action.claimStop(process)
+	if !claimed {
 		return rv
 	}
-	visitor.stopped = true
+	rv = true
+
+	if action.isCancelled() {
+		action.step(process, "not stopped, the action was cancelled")
+		action.finishStop(process, false)
+		return false
+	}
 
 	c.monitorUnset(process)
 
 	if process.IsRunning() {
-		process.StopProcess()
-		if process.waitState(processStopped) != processStopped {
-			rv = false
+		slots := c.takeSlot(action)
+		// a failing pre_stop hook doesn't keep the process from stopping
+		c.runHooks(process, HOOK_PRE_STOP, action)
+		if rv = c.stopAndEscalate(process, action); rv {
+			c.runHooks(process, HOOK_POST_STOP, action)
 		}
+		<-slots
+	}
+	if rv {
+		process.removeCgroup()
 	}
 
+	action.finishStop(process, rv)
 	return rv
 }
 
+// Stops a running process with its stop program or stop signal.  If it, or
+// anything left in its process group, is still running after the grace
+// period, the group is sent SIGKILL.
+func (c *Control) stopAndEscalate(process *Process, action *ControlAction) bool {
+	// the stop program may remove the pidfile before the process is gone,
+	// and the process may exit before the workers it forked
+	pid, _ := process.Pid()
+	pgid := processGroup(pid)
+
+	if process.Stop != "" {
+		action.step(process, "running stop program")
+	} else {
+		action.step(process, "sending %v", signalName(process.stopSignal()))
+	}
+	if err := process.StopProcess(); err != nil {
+		action.step(process, "stop failed: %v", err)
+	}
+
+	grace := process.stopGracePeriod()
+	deadline := time.Now().Add(grace)
+	stopped := !process.pollState(grace, processStopped)
+	for stopped && groupRunning(pgid) && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if stopped && !groupRunning(pgid) {
+		Log.Infof("process %q stopped", process.FullName())
+		return true
+	}
+
+	if stopped {
+		action.step(process, "process group still running after %v, "+
+			"sending SIGKILL", grace)
+		if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil {
+			action.step(process, "SIGKILL failed: %v", err)
+		}
+		return true
+	}
+
+	if pid == 0 {
+		pid, _ = process.Pid()
+	}
+	action.step(process, "still running after %v, sending SIGKILL", grace)
+	if err := process.signal(pid, syscall.SIGKILL); err != nil {
+		action.step(process, "SIGKILL failed: %v", err)
+	}
+	if process.waitState(processStopped, killTimeout) != processStopped {
+		action.fail(&TimeoutError{process.FullName(), "stop",
+			grace + killTimeout})
+		return false
+	}
+	return true
+}
+
 // Enable monitoring for Process dependencies and given Process.
 func (c *Control) doMonitor(process *Process, action *ControlAction) {
-	if action.visitorOf(process).started {
+	if action.isStarted(process) {
 		return
 	}
 
 	for _, d := range process.DependsOn {
-		parent, err := c.Config().FindProcess(d)
+		parent, err := c.Config().FindDependency(process, d)
 		if err != nil {
 			panic(err)
 		}
@@ -330,40 +821,47 @@ func (c *Control) doMonitor(process *Process, action *ControlAction) {
 
 // Disable monitoring for the given Process
 func (c *Control) doUnmonitor(process *Process, action *ControlAction) {
-	visitor := action.visitorOf(process)
-	if visitor.stopped {
+	if claimed, _ := action.claimStop(process); !claimed {
 		return
 	}
 
-	visitor.stopped = true
 	c.monitorUnset(process)
+	action.finishStop(process, true)
 }
 
 // Apply actions to processes that depend on the given Process
 func (c *Control) doDepend(process *Process, method int, action *ControlAction) {
+	var children []*Process
 	c.ConfigManager.VisitProcesses(func(child *Process) bool {
 		for _, dep := range child.DependsOn {
-			if dep == process.Name {
-				switch method {
-				case ACTION_START:
-					c.doStart(child, action)
-				case ACTION_MONITOR:
-					c.doMonitor(child, action)
-				}
-
-				c.doDepend(child, method, action)
-
-				switch method {
-				case ACTION_STOP:
-					c.doStop(child, action)
-				case ACTION_UNMONITOR:
-					c.doUnmonitor(child, action)
-				}
+			parent, _ := c.ConfigManager.FindDependency(child, dep)
+			if parent == process {
+				children = append(children, child)
 				break
 			}
 		}
 		return true
 	})
+
+	visitEach(children, func(child *Process) {
+		switch method {
+		case ACTION_START:
+			if !c.doStart(child, action) {
+				return
+			}
+		case ACTION_MONITOR:
+			c.doMonitor(child, action)
+		}
+
+		c.doDepend(child, method, action)
+
+		switch method {
+		case ACTION_STOP:
+			c.doStop(child, action)
+		case ACTION_UNMONITOR:
+			c.doUnmonitor(child, action)
+		}
+	})
 }
 
 func (c *Control) monitorSet(process *Process) {
@@ -373,7 +871,7 @@ func (c *Control) monitorSet(process *Process) {
 	if state.Monitor == MONITOR_NOT {
 		state.Monitor = MONITOR_INIT
 		c.EventMonitor.StartMonitoringProcess(process)
-		Log.Infof("%q monitoring enabled", process.Name)
+		Log.Infof("%q monitoring enabled", process.FullName())
 	}
 }
 
@@ -389,7 +887,7 @@ func (c *Control) monitorActivate(process *Process) bool {
 
 	if state.Monitor != MONITOR_YES {
 		state.Monitor = MONITOR_YES // INIT -> YES
-		Log.Infof("%q monitoring activated", process.Name)
+		Log.Infof("%q monitoring activated", process.FullName())
 	}
 
 	return true
@@ -401,22 +899,30 @@ func (c *Control) monitorUnset(process *Process) {
 	defer state.MonitorLock.Unlock()
 	if state.Monitor != MONITOR_NOT {
 		state.Monitor = MONITOR_NOT
-		Log.Infof("%q monitoring disabled", process.Name)
+		Log.Infof("%q monitoring disabled", process.FullName())
 	}
 }
 
-func (c *Control) isActionPending(process *Process) bool {
+func (c *Control) setActionPending(process *Process, actionPending bool) {
 	state := c.State(process)
 	state.actionPendingLock.Lock()
 	defer state.actionPendingLock.Unlock()
-	return state.actionPending
+	state.actionPending = actionPending
+	state.pendingJob = 0
 }
 
-func (c *Control) setActionPending(process *Process, actionPending bool) {
+// Marks an action of the given job as pending on the process, unless one
+// already is.  Returns the job of the pending action, 0 if it has none.
+func (c *Control) claimActionPending(process *Process, jobId int) (int, bool) {
 	state := c.State(process)
 	state.actionPendingLock.Lock()
 	defer state.actionPendingLock.Unlock()
-	state.actionPending = actionPending
+	if state.actionPending {
+		return state.pendingJob, false
+	}
+	state.actionPending = true
+	state.pendingJob = jobId
+	return jobId, true
 }
 
 func (c *Control) IsMonitoring(process *Process) bool {
@@ -429,6 +935,7 @@ func (c *Control) IsMonitoring(process *Process) bool {
 // Poll process for expected state change
 func (p *Process) pollState(timeout time.Duration, expect int) bool {
 	isRunning := false
+	readiness := newReadiness(p)
 	timeoutTicker := time.NewTicker(timeout)
 	pollTicker := time.NewTicker(100 * time.Millisecond)
 	defer timeoutTicker.Stop()
@@ -442,6 +949,12 @@ func (p *Process) pollState(timeout time.Duration, expect int) bool {
 		case <-pollTicker.C:
 			isRunning = p.IsRunning()
 
+			// a process isn't started until its readiness probes pass
+			if expect == processStarted && isRunning && !readiness.ready() {
+				isRunning = false
+				continue
+			}
+
 			if (expect == processStopped && !isRunning) ||
 				(expect == processStarted && isRunning) {
 				return isRunning
@@ -452,24 +965,58 @@ func (p *Process) pollState(timeout time.Duration, expect int) bool {
 	panic("not reached")
 }
 
-// Wait for a Process to change state.
-func (p *Process) waitState(expect int) int {
-	timeout := 30 * time.Second // XXX TODO process.Timeout
+// Returns a configured timeout, or the default if it isn't set.
+func parseTimeout(timeout string, defaultTimeout string) time.Duration {
+	if duration, err := time.ParseDuration(timeout); err == nil {
+		return duration
+	}
+	duration, _ := time.ParseDuration(defaultTimeout)
+	return duration
+}
+
+func (p *Process) startTimeout() time.Duration {
+	return parseTimeout(p.StartTimeout, DEFAULT_START_TIMEOUT)
+}
+
+func (p *Process) stopTimeout() time.Duration {
+	return parseTimeout(p.StopTimeout, DEFAULT_STOP_TIMEOUT)
+}
+
+// How long a process is given to exit after being asked to stop, before it
+// is killed.  Defaults to the stop timeout.
+func (p *Process) stopGracePeriod() time.Duration {
+	if p.StopGracePeriod == "" {
+		return p.stopTimeout()
+	}
+	return parseTimeout(p.StopGracePeriod, DEFAULT_STOP_TIMEOUT)
+}
+
+func (p *Process) restartTimeout() time.Duration {
+	if p.RestartTimeout == "" {
+		return p.startTimeout()
+	}
+	return parseTimeout(p.RestartTimeout, DEFAULT_START_TIMEOUT)
+}
+
+// Wait for a Process to change state, for at most timeout.
+func (p *Process) waitState(expect int, timeout time.Duration) int {
 	isRunning := p.pollState(timeout, expect)
 
 	// XXX TODO emit events when process state changes
 	if isRunning {
 		if expect == processStarted {
-			Log.Infof("process %q started", p.Name)
+			Log.Infof("process %q started", p.FullName())
 		} else {
-			Log.Errorf("process %q failed to stop", p.Name)
+			Log.Errorf("process %q failed to stop: timed out after %v",
+				p.FullName(), timeout)
 		}
 		return processStarted
 	} else {
 		if expect == processStarted {
-			Log.Errorf("process %q failed to start", p.Name)
+			Log.Errorf("process %q failed to start: timed out after %v",
+				p.FullName(), timeout)
 		} else {
-			Log.Infof("process %q stopped", p.Name)
+			Log.Infof("process %q stopped", p.FullName())
 		}
 		return processStopped
 	}
@@ -478,7 +1025,7 @@ func (p *Process) waitState(expect int) int {
 }
 
 func (c *Control) LoadPersistState() error {
-	states := map[string]ProcessState{}
+	states := map[string]*ProcessState{}
 	persistFile := c.ConfigManager.Settings.PersistFile
 	_, err := os.Stat(persistFile)
 	if err != nil {
@@ -494,19 +1041,51 @@ func (c *Control) LoadPersistState() error {
 	}
 	for _, processGroup := range c.ConfigManager.ProcessGroups {
 		for name, process := range processGroup.Processes {
-			if state, hasKey := states[name]; hasKey {
-				*c.State(process) = state
+			state, hasKey := states[process.FullName()]
+			if !hasKey {
+				// state persisted before processes were keyed by group
+				state, hasKey = states[name]
+				if found, err := c.ConfigManager.FindProcess(name); err != nil ||
+					found != process {
+					hasKey = false
+				}
+			}
+			if hasKey && state != nil {
+				c.withState(process, func(current *ProcessState) {
+					current.restore(state)
+				})
 			}
 		}
 	}
 	return nil
 }
 
+// Persists the states if the identity of the process was recorded since
+// they were last persisted, e.g. when it was found running rather than
+// started by gonit.
+func (c *Control) persistIdentity(process *Process) {
+	changed := false
+	process.withState(func(state *ProcessState) {
+		changed = state.identityChanged
+	})
+	if !changed {
+		return
+	}
+	if err := c.PersistStates(c.States); err != nil {
+		Log.Errorf("Error persisting state: '%v'", err.Error())
+	}
+}
+
 func (c *Control) PersistStates(states map[string]*ProcessState) error {
 	c.persistLock.Lock()
 	defer c.persistLock.Unlock()
 
+	c.statesLock.Lock()
 	yaml, err := goyaml.Marshal(states)
+	for _, state := range states {
+		state.identityChanged = false
+	}
+	c.statesLock.Unlock()
 	if err != nil {
 		return err
 	}
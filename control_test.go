@@ -6,8 +6,12 @@ import (
 	"fmt"
 	. "github.com/cloudfoundry/gonit"
 	"github.com/cloudfoundry/gonit/test/helper"
+	"github.com/cloudfoundry/gosigar"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 type ControlSuite struct{}
@@ -37,6 +41,13 @@ func (s *ControlSuite) TearDownTest(c *C) {
 
 func (fem *FakeEventMonitor) Stop() {}
 
+func (fem *FakeEventMonitor) CleanDataForProcess(process *Process) {}
+
+func (fem *FakeEventMonitor) Reload(configManager *ConfigManager,
+	control *Control) error {
+	return nil
+}
+
 func (s *ControlSuite) TestActions(c *C) {
 	fem := &FakeEventMonitor{}
 	configManager := &ConfigManager{
@@ -242,3 +253,380 @@ func (s *ControlSuite) TestPersistData(c *C) {
 	c.Check(3, Equals, control.States["MyProcess"].Starts)
 	c.Check(2, Equals, control.States["MyProcess"].Monitor)
 }
+
+func (s *ControlSuite) TestLoadPersistStateByGroup(c *C) {
+	persistFile := os.Getenv("PWD") + "/test/config/test_group_persist_file.yml"
+	defer os.Remove(persistFile)
+	configManager := &ConfigManager{Settings: &Settings{PersistFile: persistFile}}
+	control := &Control{ConfigManager: configManager}
+	web := &Process{Name: "worker"}
+	batch := &Process{Name: "worker"}
+	db := &Process{Name: "db"}
+	control.Config().AddProcess("web", web)
+	control.Config().AddProcess("batch", batch)
+	control.Config().AddProcess("web", db)
+
+	// "db" was persisted by an older gonit, before states were keyed by group
+	states := map[string]*ProcessState{
+		"web/worker":   &ProcessState{Starts: 1},
+		"batch/worker": &ProcessState{Starts: 2},
+		"db":           &ProcessState{Starts: 3},
+	}
+	err := control.PersistStates(states)
+	c.Check(err, IsNil)
+	err = control.LoadPersistState()
+	c.Check(err, IsNil)
+
+	c.Check(1, Equals, control.State(web).Starts)
+	c.Check(2, Equals, control.State(batch).Starts)
+	c.Check(3, Equals, control.State(db).Starts)
+	c.Check(control.States["web/db"], NotNil)
+}
+
+func (s *ControlSuite) TestStartTimeout(c *C) {
+	configManager := &ConfigManager{
+		Settings: &Settings{PersistFile: gonitPersistFile},
+	}
+	api := NewAPI(configManager)
+	api.Control.EventMonitor = &FakeEventMonitor{}
+
+	process := helper.NewTestProcess("timeout", nil, false)
+	defer helper.Cleanup(process)
+	// never writes the pidfile, so never counts as started
+	process.Start = "sleep 5"
+	process.StartTimeout = "500ms"
+
+	err := api.Control.Config().AddProcess(groupName, process)
+	c.Assert(err, IsNil)
+
+	result := &ActionResult{}
+	err = api.StartProcess(process.Name, result)
+	c.Check(err, ErrorMatches, `.*process ".*timeout" did not start within 500ms`)
+	c.Check(result.Total, Equals, 1)
+	c.Check(result.Errors, Equals, 1)
+	c.Check(result.Timeouts, Equals, 1)
+}
+
+// Waits for pid to be gone, or only a zombie.
+func checkKilled(c *C, pid int) {
+	for i := 0; i < 20; i++ {
+		state := sigar.ProcState{}
+		if state.Get(pid) != nil || state.State == sigar.RunStateZombie {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Errorf("worker %d survived SIGKILL", pid)
+}
+
+func (s *ControlSuite) TestStopEscalation(c *C) {
+	dir := c.MkDir()
+
+	// ignores SIGTERM, along with the worker it forks
+	childPidfile := filepath.Join(dir, "worker.pid")
+	script := filepath.Join(dir, "stubborn")
+	err := ioutil.WriteFile(script, []byte("#!/bin/sh\ntrap '' TERM\n"+
+		"sleep 60 &\necho $! > "+childPidfile+"\nwait\n"), 0755)
+	c.Assert(err, IsNil)
+
+	configManager := &ConfigManager{
+		Settings: &Settings{PersistFile: gonitPersistFile},
+	}
+	api := NewAPI(configManager)
+	api.Control.EventMonitor = &FakeEventMonitor{}
+	process := &Process{
+		Name:            "stubborn",
+		Start:           script,
+		Pidfile:         filepath.Join(dir, "stubborn.pid"),
+		Supervise:       true,
+		StopGracePeriod: "500ms",
+	}
+	c.Assert(api.Control.Config().AddProcess(groupName, process), IsNil)
+
+	result := &ActionResult{}
+	c.Assert(api.StartProcess(process.Name, result), IsNil)
+	time.Sleep(200 * time.Millisecond)
+	childPid, err := ReadPidFile(childPidfile)
+	c.Assert(err, IsNil)
+
+	result = &ActionResult{}
+	err = api.StopProcess(process.Name, result)
+	c.Check(err, IsNil)
+	c.Check(result.Errors, Equals, 0)
+	messages := []string{}
+	for _, step := range result.Steps {
+		c.Check(step.Process, Equals, groupName+"/stubborn")
+		messages = append(messages, step.Message)
+	}
+	c.Check(messages, DeepEquals, []string{
+		"sending SIGTERM",
+		"still running after 500ms, sending SIGKILL",
+	})
+	c.Check(process.IsRunning(), Equals, false)
+
+	// the forked worker was killed along with its process group
+	checkKilled(c, childPid)
+}
+
+func (s *ControlSuite) TestStopEscalationWorker(c *C) {
+	dir := c.MkDir()
+
+	// stops on SIGTERM, but the worker it forks ignores it
+	childPidfile := filepath.Join(dir, "worker.pid")
+	script := filepath.Join(dir, "leader")
+	err := ioutil.WriteFile(script, []byte("#!/bin/sh\n"+
+		"(trap '' TERM; exec sleep 60) &\necho $! > "+childPidfile+
+		"\nwait\n"), 0755)
+	c.Assert(err, IsNil)
+
+	configManager := &ConfigManager{
+		Settings: &Settings{PersistFile: gonitPersistFile},
+	}
+	api := NewAPI(configManager)
+	api.Control.EventMonitor = &FakeEventMonitor{}
+	process := &Process{
+		Name:            "leader",
+		Start:           script,
+		Pidfile:         filepath.Join(dir, "leader.pid"),
+		Supervise:       true,
+		StopGracePeriod: "500ms",
+	}
+	c.Assert(api.Control.Config().AddProcess(groupName, process), IsNil)
+
+	result := &ActionResult{}
+	c.Assert(api.StartProcess(process.Name, result), IsNil)
+	time.Sleep(200 * time.Millisecond)
+	childPid, err := ReadPidFile(childPidfile)
+	c.Assert(err, IsNil)
+
+	result = &ActionResult{}
+	c.Check(api.StopProcess(process.Name, result), IsNil)
+	c.Check(result.Errors, Equals, 0)
+	messages := []string{}
+	for _, step := range result.Steps {
+		messages = append(messages, step.Message)
+	}
+	c.Check(messages, DeepEquals, []string{
+		"sending SIGTERM",
+		"process group still running after 500ms, sending SIGKILL",
+	})
+	c.Check(process.IsRunning(), Equals, false)
+	checkKilled(c, childPid)
+}
+
+func (s *ControlSuite) TestHooks(c *C) {
+	dir := c.MkDir()
+
+	configManager := &ConfigManager{
+		Settings: &Settings{PersistFile: gonitPersistFile},
+	}
+	api := NewAPI(configManager)
+	api.Control.EventMonitor = &FakeEventMonitor{}
+	marker := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+	pidfile := filepath.Join(dir, "hooked.pid")
+	process := &Process{
+		Name:    "hooked",
+		Start:   "echo $$ > " + pidfile + "; exec sleep 60",
+		Shell:   true,
+		Pidfile: pidfile,
+		Hooks: &Hooks{
+			PreStart:  []*Hook{{Command: "touch " + marker("pre_start")}},
+			PostStart: []*Hook{{Command: "touch " + marker("post_start")}},
+			PreStop:   []*Hook{{Command: "sleep 5", Timeout: "200ms"}},
+			PostStop:  []*Hook{{Command: "touch " + marker("post_stop")}},
+		},
+	}
+	c.Assert(api.Control.Config().AddProcess(groupName, process), IsNil)
+
+	result := &ActionResult{}
+	c.Assert(api.StartProcess(process.Name, result), IsNil)
+	for _, name := range []string{"pre_start", "post_start"} {
+		_, err := os.Stat(marker(name))
+		c.Check(err, IsNil)
+	}
+
+	// a pre_stop hook timing out doesn't keep the process from stopping
+	result = &ActionResult{}
+	err := api.StopProcess(process.Name, result)
+	c.Check(err, ErrorMatches, `.*pre_stop hook 'sleep 5' failed: timed out `+
+		`after 200ms`)
+	c.Check(result.Errors, Equals, 1)
+	c.Check(process.IsRunning(), Equals, false)
+	_, err = os.Stat(marker("post_stop"))
+	c.Check(err, IsNil)
+
+	// a failing pre_start hook aborts the start
+	process.Hooks.PreStart = []*Hook{{Command: "false"}}
+	result = &ActionResult{}
+	err = api.StartProcess(process.Name, result)
+	c.Check(err, ErrorMatches, `.*pre_start hook 'false' failed: exit status 1`)
+	c.Check(result.Errors, Equals, 1)
+	c.Check(result.Steps, DeepEquals, []ActionStep{
+		{groupName + "/hooked", "running pre_start hook 'false'"},
+	})
+	c.Check(process.IsRunning(), Equals, false)
+}
+
+func (s *ControlSuite) TestStartWaitsForReady(c *C) {
+	dir := c.MkDir()
+
+	configManager := &ConfigManager{
+		Settings: &Settings{PersistFile: gonitPersistFile},
+	}
+	api := NewAPI(configManager)
+	api.Control.EventMonitor = &FakeEventMonitor{}
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	// running right away, but only ready a little later
+	db := &Process{
+		Name:    "db",
+		Pidfile: path("db.pid"),
+		Start: "echo $$ > " + path("db.pid") + "; sleep 0.5; touch " +
+			path("db.ready") + "; exec sleep 60",
+		Shell: true,
+		Ready: []*Probe{{File: path("db.ready")}},
+	}
+	web := &Process{
+		Name:    "web",
+		Pidfile: path("web.pid"),
+		Start: "test -f " + path("db.ready") + " && touch " +
+			path("web.saw_db") + "; echo $$ > " + path("web.pid") +
+			"; exec sleep 60",
+		Shell:     true,
+		DependsOn: []string{"db"},
+	}
+	c.Assert(api.Control.Config().AddProcess(groupName, db), IsNil)
+	c.Assert(api.Control.Config().AddProcess(groupName, web), IsNil)
+	defer func() {
+		api.StopProcess(web.Name, &ActionResult{})
+		api.StopProcess(db.Name, &ActionResult{})
+	}()
+
+	c.Assert(api.StartProcess(web.Name, &ActionResult{}), IsNil)
+	_, err := os.Stat(path("web.saw_db"))
+	c.Check(err, IsNil)
+}
+
+func (s *ControlSuite) TestParentNeverReady(c *C) {
+	dir := c.MkDir()
+
+	configManager := &ConfigManager{
+		Settings: &Settings{PersistFile: gonitPersistFile},
+	}
+	api := NewAPI(configManager)
+	api.Control.EventMonitor = &FakeEventMonitor{}
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	// running right away, but never ready
+	db := &Process{
+		Name:         "db",
+		Pidfile:      path("db.pid"),
+		Start:        "echo $$ > " + path("db.pid") + "; exec sleep 60",
+		Shell:        true,
+		StartTimeout: "500ms",
+		Ready:        []*Probe{{File: path("db.ready")}},
+	}
+	web := &Process{
+		Name:      "web",
+		Pidfile:   path("web.pid"),
+		Start:     "echo $$ > " + path("web.pid") + "; exec sleep 60",
+		Shell:     true,
+		DependsOn: []string{"db"},
+	}
+	c.Assert(api.Control.Config().AddProcess(groupName, db), IsNil)
+	c.Assert(api.Control.Config().AddProcess(groupName, web), IsNil)
+	defer func() {
+		api.StopProcess(web.Name, &ActionResult{})
+		api.StopProcess(db.Name, &ActionResult{})
+	}()
+
+	result := &ActionResult{}
+	err := api.StartProcess(web.Name, result)
+	c.Check(err, ErrorMatches, `.*process ".*db" did not start within 500ms`)
+	c.Check(result.Timeouts, Equals, 1)
+	c.Check(result.Steps, DeepEquals, []ActionStep{
+		{groupName + "/db", "starting"},
+		{groupName + "/db", "not started or not ready after 500ms"},
+		{groupName + "/web", `not started, "` + groupName +
+			`/db" failed to start`},
+	})
+	c.Check(web.IsRunning(), Equals, false)
+	c.Check(api.Control.State(web).Starts, Equals, 0)
+}
+
+func (s *ControlSuite) TestParallelGroup(c *C) {
+	dir := c.MkDir()
+
+	configManager := &ConfigManager{
+		Settings: &Settings{PersistFile: gonitPersistFile},
+	}
+	api := NewAPI(configManager)
+	api.Control.EventMonitor = &FakeEventMonitor{}
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+	group := "parallel"
+	processes := []*Process{}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		process := &Process{
+			Name:    name,
+			Pidfile: path(name + ".pid"),
+			Start: "sleep 0.3; echo $$ > " + path(name+".pid") +
+				"; exec sleep 60",
+			Shell: true,
+		}
+		processes = append(processes, process)
+	}
+	// 'a' is only stopped once 'dependent' has stopped
+	processes[0].Stop = "kill -0 `cat " + path("dependent.pid") +
+		"` && touch " + path("stopped_early") + "; kill `cat " +
+		path("a.pid") + "`"
+	dependent := &Process{
+		Name:    "dependent",
+		Pidfile: path("dependent.pid"),
+		Start: "test -f " + path("a.pid") + " && echo $$ > " +
+			path("dependent.pid") + "; exec sleep 60",
+		Shell:     true,
+		DependsOn: []string{"a"},
+	}
+	processes = append(processes, dependent)
+	for _, process := range processes {
+		c.Assert(api.Control.Config().AddProcess(group, process), IsNil)
+	}
+	defer api.StopGroup(group, &ActionResult{})
+
+	start := time.Now()
+	result := &ActionResult{}
+	c.Assert(api.StartGroup(group, result), IsNil)
+	c.Check(time.Since(start) < time.Second, Equals, true)
+	c.Check(result.Total, Equals, 5)
+	c.Check(result.Errors, Equals, 0)
+	for _, process := range processes {
+		c.Check(process.IsRunning(), Equals, true)
+		c.Check(api.Control.State(process).Starts, Equals, 1)
+	}
+
+	result = &ActionResult{}
+	c.Assert(api.StopGroup(group, result), IsNil)
+	c.Check(result.Errors, Equals, 0)
+	for _, process := range processes {
+		c.Check(process.IsRunning(), Equals, false)
+	}
+	_, err := os.Stat(path("stopped_early"))
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	// one process at a time
+	configManager.Settings.ActionConcurrency = 1
+	start = time.Now()
+	c.Assert(api.StartGroup(group, &ActionResult{}), IsNil)
+	c.Check(time.Since(start) >= 1200*time.Millisecond, Equals, true)
+	for _, process := range processes {
+		c.Check(process.IsRunning(), Equals, true)
+	}
+}
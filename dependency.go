@@ -0,0 +1,149 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Processes name what they depend on in 'dependson'.  A bare name refers to
+// a process in the same group, "group/process" to one in any group.  The
+// dependencies of every group together form one graph, which must not have
+// cycles.
+
+type DependencyGraph struct {
+	// Processes in group/process order.
+	Processes []*Process
+	// Maps a process to the processes it depends on.
+	DependsOn map[*Process][]*Process
+}
+
+// Builds the dependency graph of every process in the config.  Dependencies
+// that can't be resolved are returned as ConfigErrors and left out of the
+// graph.
+func (c *ConfigManager) DependencyGraph() (*DependencyGraph, error) {
+	errs := ConfigErrors{}
+	graph := &DependencyGraph{DependsOn: map[*Process][]*Process{}}
+	for _, pg := range c.sortedGroups() {
+		for _, process := range pg.sortedProcesses() {
+			graph.Processes = append(graph.Processes, process)
+			for _, name := range process.DependsOn {
+				parent, err := c.FindDependency(process, name)
+				if err != nil {
+					errs.add(fmt.Errorf("Process %v has an unknown dependson "+
+						"'%v'.", process.FullName(), name))
+					continue
+				}
+				graph.DependsOn[process] = append(graph.DependsOn[process], parent)
+			}
+		}
+	}
+	return graph, errs.errOrNil()
+}
+
+// Returns every dependency cycle in the graph, each as the list of
+// processes along it with the first process repeated at the end.
+func (g *DependencyGraph) Cycles() [][]*Process {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[*Process]int{}
+	cycles := [][]*Process{}
+	path := []*Process{}
+
+	var visit func(process *Process)
+	visit = func(process *Process) {
+		state[process] = visiting
+		path = append(path, process)
+		for _, parent := range g.DependsOn[process] {
+			switch state[parent] {
+			case unvisited:
+				visit(parent)
+			case visiting:
+				for i := len(path) - 1; i >= 0; i-- {
+					if path[i] == parent {
+						cycle := append([]*Process{}, path[i:]...)
+						cycles = append(cycles, append(cycle, parent))
+						break
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[process] = done
+	}
+
+	for _, process := range g.Processes {
+		if state[process] == unvisited {
+			visit(process)
+		}
+	}
+	return cycles
+}
+
+// Returns a cycle as "a/x -> a/y -> a/x".
+func cycleString(cycle []*Process) string {
+	names := make([]string, len(cycle))
+	for i, process := range cycle {
+		names[i] = process.FullName()
+	}
+	return strings.Join(names, " -> ")
+}
+
+// Writes the graph in graphviz DOT format, with one cluster per group and
+// an edge from each process to the processes it depends on.
+func (g *DependencyGraph) WriteDot(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "digraph gonit {"); err != nil {
+		return err
+	}
+	groupName := ""
+	for i, process := range g.Processes {
+		if process.GroupName() != groupName || i == 0 {
+			if i != 0 {
+				fmt.Fprintln(w, "  }")
+			}
+			groupName = process.GroupName()
+			fmt.Fprintf(w, "  subgraph %q {\n", "cluster_"+groupName)
+			fmt.Fprintf(w, "    label=%q;\n", groupName)
+		}
+		fmt.Fprintf(w, "    %q [label=%q];\n", process.FullName(), process.Name)
+	}
+	if len(g.Processes) != 0 {
+		fmt.Fprintln(w, "  }")
+	}
+	for _, process := range g.Processes {
+		for _, parent := range g.DependsOn[process] {
+			fmt.Fprintf(w, "  %q -> %q;\n", process.FullName(), parent.FullName())
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// Checks that every dependency exists and that there are no cycles.
+func (c *ConfigManager) validateDependencies() error {
+	graph, err := c.DependencyGraph()
+	errs := ConfigErrors{}
+	errs.add(err)
+	for _, cycle := range graph.Cycles() {
+		errs.add(fmt.Errorf("Dependency cycle: %v.", cycleString(cycle)))
+	}
+	return errs.errOrNil()
+}
+
+// Loads the config at path and writes its dependency graph in DOT format.
+// The config isn't validated, so that a graph with cycles can be inspected.
+func (c *ConfigManager) WriteDependencyDot(path string, w io.Writer) error {
+	if err := c.parse(path); err != nil {
+		return err
+	}
+	graph, err := c.DependencyGraph()
+	if err != nil {
+		Log.Warn(err.Error())
+	}
+	return graph.WriteDot(w)
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"bytes"
+	. "launchpad.net/gocheck"
+)
+
+type DependencySuite struct{}
+
+var _ = Suite(&DependencySuite{})
+
+func dependencyConfig(c *C, deps map[string][]string) *ConfigManager {
+	configManager := &ConfigManager{ProcessGroups: map[string]*ProcessGroup{}}
+	for name, dependsOn := range deps {
+		group, process := "", name
+		for i := range name {
+			if name[i] == '/' {
+				group, process = name[:i], name[i+1:]
+			}
+		}
+		err := configManager.AddProcess(group, &Process{
+			Name:      process,
+			DependsOn: dependsOn,
+		})
+		c.Assert(err, IsNil)
+	}
+	return configManager
+}
+
+func (s *DependencySuite) TestCrossGroupDependencies(c *C) {
+	configManager := dependencyConfig(c, map[string][]string{
+		"db/postgres":  nil,
+		"web/nginx":    {"app"},
+		"web/app":      {"db/postgres"},
+		"worker/queue": {"db/postgres", "web/app"},
+	})
+	graph, err := configManager.DependencyGraph()
+	c.Assert(err, IsNil)
+	c.Check(len(graph.Cycles()), Equals, 0)
+	c.Check(configManager.validateDependencies(), IsNil)
+
+	app, _ := configManager.FindProcess("web/app")
+	postgres, _ := configManager.FindProcess("db/postgres")
+	c.Check(graph.DependsOn[app], DeepEquals, []*Process{postgres})
+
+	var dot bytes.Buffer
+	c.Assert(graph.WriteDot(&dot), IsNil)
+	c.Check(dot.String(), Equals, `digraph gonit {
+  subgraph "cluster_db" {
+    label="db";
+    "db/postgres" [label="postgres"];
+  }
+  subgraph "cluster_web" {
+    label="web";
+    "web/app" [label="app"];
+    "web/nginx" [label="nginx"];
+  }
+  subgraph "cluster_worker" {
+    label="worker";
+    "worker/queue" [label="queue"];
+  }
+  "web/app" -> "db/postgres";
+  "web/nginx" -> "web/app";
+  "worker/queue" -> "db/postgres";
+  "worker/queue" -> "web/app";
+}
+`)
+}
+
+func (s *DependencySuite) TestDependencyErrors(c *C) {
+	configManager := dependencyConfig(c, map[string][]string{
+		"a/one":   {"two"},
+		"a/two":   {"b/three"},
+		"b/three": {"a/one"},
+		"b/four":  {"four", "nope", "c/five"},
+	})
+	err := configManager.validateDependencies()
+	c.Assert(err, NotNil)
+	messages := []string{}
+	for _, err := range err.(ConfigErrors) {
+		messages = append(messages, err.Error())
+	}
+	c.Check(messages, DeepEquals, []string{
+		"Process b/four has an unknown dependson 'nope'.",
+		"Process b/four has an unknown dependson 'c/five'.",
+		"Dependency cycle: a/one -> a/two -> b/three -> a/one.",
+		"Dependency cycle: b/four -> b/four.",
+	})
+}
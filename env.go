@@ -0,0 +1,336 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+)
+
+// The environment of a process is built up in this order, later entries
+// replacing earlier ones with the same name:
+//
+//   - PATH=/bin:/usr/bin:/sbin:/usr/sbin
+//   - gonit's own variables allowed by 'inherit_env', which is 'none' (the
+//     default), 'all' or a list of names
+//   - HOME, USER and LOGNAME of the uid the process runs as
+//   - GONIT_PROCESS and GONIT_GROUP
+//   - the variables in 'env_file', in dotenv format
+//   - the variables in 'env'
+//
+// Values in env_file and env may refer to ${VAR} and ${VAR:-default}, which
+// are looked up in the entries before them and then in gonit's environment,
+// when a program of the process is run.  For example:
+//
+//   web:
+//     inherit_env: [LANG, TZ]
+//     env_file: /var/vcap/jobs/web/config/web.env
+//     env:
+//       - LOG_DIR=/var/vcap/sys/log/${GONIT_PROCESS}
+
+const (
+	INHERIT_ENV_NONE  = "none"
+	INHERIT_ENV_ALL   = "all"
+	GONIT_PROCESS_ENV = "GONIT_PROCESS"
+	GONIT_GROUP_ENV   = "GONIT_GROUP"
+)
+
+// What status shows instead of the values of secret variables.
+const MASKED_ENV_VALUE = "********"
+
+// Variables whose names contain any of these are masked in status.
+var secretEnvWords = []string{"SECRET", "PASSWORD", "PASSWD", "TOKEN", "KEY",
+	"CREDENTIAL", "PRIVATE"}
+
+// An environment that keeps variables in the order they were first set.
+type environment struct {
+	names  []string
+	values map[string]string
+}
+
+func newEnvironment() *environment {
+	return &environment{values: map[string]string{}}
+}
+
+func (e *environment) set(name string, value string) {
+	if _, exists := e.values[name]; !exists {
+		e.names = append(e.names, name)
+	}
+	e.values[name] = value
+}
+
+// Looks a variable up for ${VAR} expansion.
+func (e *environment) lookup(name string) string {
+	if value, exists := e.values[name]; exists {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+func (e *environment) strings() []string {
+	env := make([]string, len(e.names))
+	for i, name := range e.names {
+		env[i] = name + "=" + e.values[name]
+	}
+	return env
+}
+
+// Returns the names of gonit's variables the process inherits, or nil for
+// all of them.
+func (p *Process) inheritedEnvNames() ([]string, error) {
+	switch value := p.InheritEnv.(type) {
+	case nil:
+		return []string{}, nil
+	case string:
+		switch value {
+		case INHERIT_ENV_NONE:
+			return []string{}, nil
+		case INHERIT_ENV_ALL:
+			return nil, nil
+		}
+	case []interface{}:
+		names := []string{}
+		for _, name := range value {
+			s, ok := name.(string)
+			if !ok || s == "" || strings.Contains(s, "=") {
+				return nil, fmt.Errorf("'%v' is not a variable name", name)
+			}
+			names = append(names, s)
+		}
+		return names, nil
+	}
+	return nil, fmt.Errorf("must be '%v', '%v' or a list of names, not '%v'",
+		INHERIT_ENV_NONE, INHERIT_ENV_ALL, p.InheritEnv)
+}
+
+// Returns the environment the programs of the process run with.  account is
+// the user the process runs as, if it has one.
+func (p *Process) environment(account *user.User) ([]string, error) {
+	env := newEnvironment()
+	env.set("PATH", DEFAULT_ENV_PATH[len("PATH="):])
+
+	names, err := p.inheritedEnvNames()
+	if err != nil {
+		return nil, fmt.Errorf("inherit_env %v", err)
+	}
+	if names == nil {
+		for _, variable := range os.Environ() {
+			if eq := strings.Index(variable, "="); eq > 0 {
+				env.set(variable[:eq], variable[eq+1:])
+			}
+		}
+	}
+	for _, name := range names {
+		if value, exists := lookupEnv(name); exists {
+			env.set(name, value)
+		}
+	}
+
+	if account != nil {
+		env.set("HOME", account.HomeDir)
+		env.set("USER", account.Username)
+		env.set("LOGNAME", account.Username)
+	}
+	env.set(GONIT_PROCESS_ENV, p.Name)
+	env.set(GONIT_GROUP_ENV, p.groupName)
+
+	if p.EnvFile != "" {
+		entries, err := readEnvFile(p.EnvFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if err := env.setExpanded(entry); err != nil {
+				return nil, fmt.Errorf("%v: %v", p.EnvFile, err)
+			}
+		}
+	}
+	for _, variable := range p.Env {
+		entry, err := parseEnvEntry(variable)
+		if err != nil {
+			return nil, err
+		}
+		if err := env.setExpanded(entry); err != nil {
+			return nil, err
+		}
+	}
+	return env.strings(), nil
+}
+
+// Returns the value of a variable in env, the last one if it is set more
+// than once.
+func envValue(env []string, name string) string {
+	value := ""
+	for _, variable := range env {
+		if strings.HasPrefix(variable, name+"=") {
+			value = variable[len(name)+1:]
+		}
+	}
+	return value
+}
+
+// os.Getenv can't tell unset variables from empty ones.
+func lookupEnv(name string) (string, bool) {
+	for _, variable := range os.Environ() {
+		if strings.HasPrefix(variable, name+"=") {
+			return variable[len(name)+1:], true
+		}
+	}
+	return "", false
+}
+
+// A variable from env or env_file.
+type envEntry struct {
+	name   string
+	value  string
+	expand bool
+}
+
+func (e *environment) setExpanded(entry *envEntry) error {
+	value := entry.value
+	if entry.expand {
+		var err error
+		if value, err = Interpolate(value, e.lookup); err != nil {
+			return err
+		}
+	}
+	e.set(entry.name, value)
+	return nil
+}
+
+// Parses a NAME=value entry of env.
+func parseEnvEntry(variable string) (*envEntry, error) {
+	eq := strings.Index(variable, "=")
+	if eq <= 0 {
+		return nil, fmt.Errorf("'%v' is not NAME=value", variable)
+	}
+	return &envEntry{variable[:eq], variable[eq+1:], true}, nil
+}
+
+// Reads a dotenv file: NAME=value lines, optionally starting with 'export'.
+// Blank lines and lines starting with '#' are skipped.  Values in single
+// quotes are taken as they are, values in double quotes may use \n, \t, \"
+// and \\ escapes.  Unquoted and double quoted values are expanded.
+func readEnvFile(path string) ([]*envEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseEnvFile(file, path)
+}
+
+func parseEnvFile(r io.Reader, path string) ([]*envEntry, error) {
+	entries := []*envEntry{}
+	reader := bufio.NewReader(r)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if entry, parseErr := parseEnvLine(line); parseErr != nil {
+			return nil, fmt.Errorf("%v:%v: %v", path, lineNumber, parseErr)
+		} else if entry != nil {
+			entries = append(entries, entry)
+		}
+		if err == io.EOF {
+			return entries, nil
+		}
+	}
+}
+
+func parseEnvLine(line string) (*envEntry, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return nil, nil
+	}
+	if strings.HasPrefix(line, "export ") {
+		line = strings.TrimSpace(line[len("export "):])
+	}
+	entry, err := parseEnvEntry(line)
+	if err != nil {
+		return nil, err
+	}
+	entry.name = strings.TrimSpace(entry.name)
+	value := strings.TrimSpace(entry.value)
+	switch {
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		entry.value, entry.expand = value[1:len(value)-1], false
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		entry.value = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`,
+			`\\`, `\`).Replace(value[1 : len(value)-1])
+	case len(value) > 0 && (value[0] == '"' || value[0] == '\''):
+		return nil, fmt.Errorf("Unterminated quote in '%v'.", line)
+	default:
+		// comments may follow unquoted values
+		if hash := strings.Index(value, " #"); hash >= 0 {
+			value = strings.TrimSpace(value[:hash])
+		}
+		entry.value = value
+	}
+	return entry, nil
+}
+
+// Checks the env, env_file and inherit_env settings of a process, without
+// reading the env file.
+func (p *Process) validateEnv() error {
+	errs := ConfigErrors{}
+	if _, err := p.inheritedEnvNames(); err != nil {
+		errs.add(fmt.Errorf("Process %v inherit_env %v.", p.FullName(), err))
+	}
+	syntaxOnly := func(string) string { return "-" }
+	for _, variable := range p.Env {
+		entry, err := parseEnvEntry(variable)
+		if err == nil {
+			_, err = Interpolate(entry.value, syntaxOnly)
+		}
+		if err != nil {
+			errs.add(fmt.Errorf("Process %v env: %v", p.FullName(), err))
+		}
+	}
+	return errs.errOrNil()
+}
+
+// Returns whether a variable probably holds a secret.
+func isSecretEnvName(name string) bool {
+	name = strings.ToUpper(name)
+	for _, word := range secretEnvWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Replaces the values of secret variables in env.
+func maskEnv(env []string) []string {
+	masked := make([]string, len(env))
+	for i, variable := range env {
+		masked[i] = variable
+		if eq := strings.Index(variable, "="); eq > 0 &&
+			isSecretEnvName(variable[:eq]) {
+			masked[i] = variable[:eq+1] + MASKED_ENV_VALUE
+		}
+	}
+	return masked
+}
+
+// Returns the environment of the process with secrets masked, for status.
+func (p *Process) maskedEnvironment() ([]string, error) {
+	var account *user.User
+	if p.Uid != "" {
+		var err error
+		if account, err = LookupUser(p.Uid); err != nil {
+			return nil, err
+		}
+	}
+	env, err := p.environment(account)
+	if err != nil {
+		return nil, err
+	}
+	return maskEnv(env), nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type EnvSuite struct{}
+
+var _ = Suite(&EnvSuite{})
+
+const testEnvFile = `# web settings
+export DB_HOST=db.local
+DB_URL="postgres://${DB_HOST}/web\n"
+GREETING='hello ${NAME}'
+LOG_LEVEL=debug # for now
+
+`
+
+func (s *EnvSuite) TestParseEnvFile(c *C) {
+	entries, err := parseEnvFile(strings.NewReader(testEnvFile), "web.env")
+	c.Assert(err, IsNil)
+	c.Check(entries, DeepEquals, []*envEntry{
+		{"DB_HOST", "db.local", true},
+		{"DB_URL", "postgres://${DB_HOST}/web\n", true},
+		{"GREETING", "hello ${NAME}", false},
+		{"LOG_LEVEL", "debug", true},
+	})
+
+	_, err = parseEnvFile(strings.NewReader("A=1\nB='2\n"), "web.env")
+	c.Check(err, ErrorMatches, "web.env:2: Unterminated quote in 'B='2'.")
+	_, err = parseEnvFile(strings.NewReader("=1\n"), "web.env")
+	c.Check(err, ErrorMatches, "web.env:1: '=1' is not NAME=value")
+}
+
+func (s *EnvSuite) TestEnvironment(c *C) {
+	dir := c.MkDir()
+	envFile := filepath.Join(dir, "web.env")
+	c.Assert(ioutil.WriteFile(envFile, []byte(testEnvFile), 0644), IsNil)
+	os.Setenv("GONIT_TEST_LANG", "C")
+	defer os.Setenv("GONIT_TEST_LANG", "")
+
+	process := &Process{
+		Name:       "web",
+		groupName:  "app",
+		EnvFile:    envFile,
+		InheritEnv: []interface{}{"GONIT_TEST_LANG", "GONIT_TEST_UNSET"},
+		Env: []string{"LOG_DIR=/var/log/${GONIT_GROUP}/${GONIT_PROCESS}",
+			"LOG_LEVEL=info", "DB_PASSWORD=secret$$"},
+	}
+	env, err := process.environment(nil)
+	c.Assert(err, IsNil)
+	c.Check(env, DeepEquals, []string{
+		"PATH=/bin:/usr/bin:/sbin:/usr/sbin",
+		"GONIT_TEST_LANG=C",
+		"GONIT_PROCESS=web",
+		"GONIT_GROUP=app",
+		"DB_HOST=db.local",
+		"DB_URL=postgres://db.local/web\n",
+		"GREETING=hello ${NAME}",
+		"LOG_LEVEL=info",
+		"LOG_DIR=/var/log/app/web",
+		"DB_PASSWORD=secret$",
+	})
+	c.Check(maskEnv(env)[9], Equals, "DB_PASSWORD="+MASKED_ENV_VALUE)
+
+	process.InheritEnv = INHERIT_ENV_ALL
+	env, err = process.environment(nil)
+	c.Assert(err, IsNil)
+	c.Check(len(env) > 10, Equals, true)
+
+	process.Env = []string{"URL=${UNSET_GONIT_TEST_VARIABLE}"}
+	_, err = process.environment(nil)
+	c.Check(err, ErrorMatches,
+		"Variable 'UNSET_GONIT_TEST_VARIABLE' is not set.")
+}
+
+func (s *EnvSuite) TestValidateEnv(c *C) {
+	process := &Process{
+		Name:       "web",
+		InheritEnv: "some",
+		Env:        []string{"NOVALUE", "URL=${HOST"},
+	}
+	err := process.validateEnv()
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Process web inherit_env must be 'none', "+
+		"'all' or a list of names, not 'some'.\n"+
+		"Process web env: 'NOVALUE' is not NAME=value\n"+
+		"Process web env: Unterminated variable in '${HOST'.")
+}
+
+const envConfig = `---
+processes:
+  web:
+    description: web server
+    pidfile: /tmp/web.pid
+    start: /bin/sleep 60
+    dir: ${GONIT_TEST_ENV_DIR}
+    inherit_env: all
+    env:
+      - WEB_DIR=${GONIT_TEST_ENV_DIR}
+`
+
+// env is expanded when the process starts, everything else when it loads
+func (s *EnvSuite) TestLoadLeavesEnvAlone(c *C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "web-gonit.yml")
+	c.Assert(ioutil.WriteFile(path, []byte(envConfig), 0644), IsNil)
+	os.Setenv("GONIT_TEST_ENV_DIR", "/var/web")
+	defer os.Setenv("GONIT_TEST_ENV_DIR", "")
+
+	configManager := &ConfigManager{}
+	c.Assert(configManager.LoadConfig(path), IsNil)
+	web := configManager.ProcessGroups["web"].Processes["web"]
+	c.Check(web.Dir, Equals, "/var/web")
+	c.Check(web.Env, DeepEquals, []string{"WEB_DIR=${GONIT_TEST_ENV_DIR}"})
+	c.Check(web.InheritEnv, Equals, INHERIT_ENV_ALL)
+}
@@ -8,6 +8,7 @@ import (
 	"math"
 	"net"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -32,6 +33,7 @@ type ParsedEvent struct {
 	description  string
 	interval     time.Duration
 	action       string
+	cgroup       string
 }
 
 // The JSON message that is sent in alerts.
@@ -43,6 +45,9 @@ type AlertMessage struct {
 	Description string    `json:"description"`
 	Value       float64   `json:"value"`
 	Message_id  uint64    `json:"message_id"`
+	// How the programs of the process last exited, if gonit saw them exit.
+	LastExit    *ExitStatus `json:"last_exit,omitempty"`
+	LastCommand *ExitStatus `json:"last_command,omitempty"`
 }
 
 const (
@@ -109,6 +114,10 @@ type EventMonitor struct {
 	control         ControlInterface
 	startTime       int64
 	quitChan        chan bool
+	// closed when the monitoring loop has quit
+	loopDone chan bool
+	// the config lock of Control, held while the loop polls
+	configLock *sync.RWMutex
 }
 
 type ControlInterface interface {
@@ -128,12 +137,19 @@ func (e *EventMonitor) setup(configManager *ConfigManager,
 	e.resourceManager = resourceManager
 	e.configManager = configManager
 	e.registerControl(control)
+	if control != nil {
+		e.configLock = &control.configLock
+	}
 	e.events = []*ParsedEvent{}
 	for _, group := range e.configManager.ProcessGroups {
 		for _, process := range group.Processes {
 			for actionName, actions := range process.Actions {
 				for _, eventName := range actions {
 					event := group.EventByName(eventName)
+					if event == nil {
+						return fmt.Errorf("Process '%v' has an unknown event '%v' on "+
+							"action '%v'.", process.FullName(), eventName, actionName)
+					}
 					if err := e.loadEvent(event, group.Name, process,
 						actionName); err != nil {
 						return fmt.Errorf("Did not load rule '%v' on action '%v' because "+
@@ -155,27 +171,34 @@ func (e *EventMonitor) printTriggeredMessage(event *ParsedEvent,
 		event.action)
 }
 
+// Returns an action triggered by an event rule.
+func newRuleAction(method int) *ControlAction {
+	action := NewControlAction(method)
+	action.visits.byRule = true
+	return action
+}
+
 func (e *EventMonitor) triggerAction(process *Process, event *ParsedEvent,
 	resourceVal uint64) error {
 	switch event.action {
 	case "stop":
 		if e.TriggerProcessActions(process) {
 			e.printTriggeredMessage(event, resourceVal)
-			return e.control.DoAction(event.processName, NewControlAction(ACTION_STOP))
+			return e.control.DoAction(event.processName, newRuleAction(ACTION_STOP))
 		} else {
 			return nil
 		}
 	case "start":
 		if e.TriggerProcessActions(process) {
 			e.printTriggeredMessage(event, resourceVal)
-			return e.control.DoAction(event.processName, NewControlAction(ACTION_START))
+			return e.control.DoAction(event.processName, newRuleAction(ACTION_START))
 		} else {
 			return nil
 		}
 	case "restart":
 		if e.TriggerProcessActions(process) {
 			e.printTriggeredMessage(event, resourceVal)
-			return e.control.DoAction(event.processName, NewControlAction(ACTION_RESTART))
+			return e.control.DoAction(event.processName, newRuleAction(ACTION_RESTART))
 		} else {
 			return nil
 		}
@@ -198,49 +221,79 @@ func (e *EventMonitor) Start(configManager *ConfigManager,
 		return err
 	}
 	Log.Info("Starting new eventmonitor loop.")
+	quit, done := e.quitChan, make(chan bool)
+	e.loopDone = done
 	go func() {
+		defer close(done)
 		timeToWait := 1 * time.Second
 		ticker := time.NewTicker(timeToWait)
 		Log.Info("Started new eventmonitor loop.")
 		for {
 			select {
-			case <-e.quitChan:
+			case <-quit:
 				Log.Info("Quit old eventmonitor loop.")
 				ticker.Stop()
 				return
 			case <-ticker.C:
-				for _, group := range e.configManager.ProcessGroups {
-					for _, process := range group.Processes {
-						if e.IsMonitoring(process) {
-							// TODO change the GetPid to be a go routine that happens every X
-							// seconds with a lock on it so we don't have to keep opening the
-							// file.
-							pid, err := process.Pid()
-							if err != nil {
-								Log.Debugf("Could not get pid file for process '%v'. Error: "+
-									"%+v", process.Name, err)
-							}
-							e.checkRules(process, pid)
-						}
-					}
-				}
+				e.poll()
 			}
 		}
 	}()
 	return nil
 }
 
+// Checks the rules of every monitored process once.
+func (e *EventMonitor) poll() {
+	if e.configLock != nil {
+		e.configLock.RLock()
+		defer e.configLock.RUnlock()
+	}
+	for _, group := range e.configManager.ProcessGroups {
+		for _, process := range group.Processes {
+			if e.IsMonitoring(process) {
+				// TODO change the GetPid to be a go routine that happens every X
+				// seconds with a lock on it so we don't have to keep opening the
+				// file.
+				pid, err := process.Pid()
+				if err != nil {
+					Log.Debugf("Could not get pid file for process '%v'. Error: "+
+						"%+v", process.FullName(), err)
+				}
+				e.checkRules(process, pid)
+			}
+		}
+	}
+}
+
 func (e *EventMonitor) Stop() {
+	e.stopLoop()
+	e.resourceManager.CleanData()
+}
+
+// Quits the monitoring loop, if it is running, and waits for it to finish
+// its poll.
+func (e *EventMonitor) stopLoop() {
+	if e.loopDone == nil {
+		return
+	}
 	Log.Info("Quitting old eventmonitor loop.")
-	e.quitChan <- true
 	close(e.quitChan)
-	e.resourceManager.CleanData()
+	<-e.loopDone
+	e.quitChan, e.loopDone = nil, nil
+}
+
+// Restarts monitoring with the events of a new config.  Unlike Stop, the
+// resource data already collected is kept.
+func (e *EventMonitor) Reload(configManager *ConfigManager,
+	control *Control) error {
+	e.stopLoop()
+	return e.Start(configManager, control)
 }
 
 // Given a process name and a pid, this will check all the rules associated with
 // it for this time period.
 func (e *EventMonitor) checkRules(process *Process, pid int) {
-	processName := process.Name
+	processName := process.FullName()
 	diffTime := time.Now().Unix() - e.startTime
 	for _, event := range e.events {
 		interval := int64(event.interval.Seconds())
@@ -269,10 +322,17 @@ func (e *EventMonitor) checkRules(process *Process, pid int) {
 // so they can be monitored.
 func (e *EventMonitor) loadEvent(event *Event, groupName string,
 	process *Process, actionName string) error {
-	parsedEvent, err := e.parseEvent(event, groupName, process.Name, actionName)
+	parsedEvent, err := e.parseEvent(event, groupName, process.FullName(),
+		actionName)
 	if err != nil {
 		return err
 	}
+	if process.Cgroup != nil {
+		parsedEvent.cgroup = process.cgroupPath()
+	} else if cgroupResourceNames[parsedEvent.resourceName] {
+		return fmt.Errorf("Rule '%v' needs the process to have a cgroup.",
+			parsedEvent.ruleString)
+	}
 	if err = e.validateInterval(parsedEvent); err != nil {
 		return err
 	}
@@ -314,6 +374,10 @@ func (e *EventMonitor) parseRule(rule string) (uint64, int, string, error) {
 			}
 		}
 	}
+	if startLastPart >= 0 && lastPart == "" {
+		// the amount is the last character, e.g. 'oom_kill > 0'
+		lastPart = rule[startLastPart:]
+	}
 	if e.resourceManager.IsValidResourceName(firstPart) {
 		ruleAmount = lastPart
 		resourceName = firstPart
@@ -345,6 +409,24 @@ func (e *EventMonitor) parseRule(rule string) (uint64, int, string, error) {
 	return parsedAmount, returnOperator, resourceName, nil
 }
 
+// Checks the syntax of an event's rule, duration and interval.
+func (e *EventMonitor) validateEvent(event *Event) error {
+	if _, _, _, err := e.parseRule(event.Rule); err != nil {
+		return err
+	}
+	if event.Duration != "" {
+		if _, err := time.ParseDuration(event.Duration); err != nil {
+			return err
+		}
+	}
+	if event.Interval != "" {
+		if _, err := time.ParseDuration(event.Interval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Given an Event, parses the rule into amount, operator and resourceName, does
 // a few other things, then returns a ParsedEvent ready to be monitored.
 func (e *EventMonitor) parseEvent(event *Event, groupName string,
@@ -395,12 +477,27 @@ func (e *EventMonitor) parseEvent(event *Event, groupName string,
 
 // Sends an alert.
 func (e *EventMonitor) sendAlert(parsedEvent *ParsedEvent) error {
-	settings := e.configManager.Settings
+	alertMessage := &AlertMessage{
+		Action:      "alert",
+		Rule:        parsedEvent.ruleString,
+		Service:     parsedEvent.processName,
+		Description: parsedEvent.description,
+		// TODO format of date time?
+		Date: time.Now(),
+		// TODO implement.
+		Message_id: 1234,
+	}
+	if process, err := e.configManager.FindProcess(
+		parsedEvent.processName); err == nil {
+		alertMessage.LastExit, alertMessage.LastCommand = process.LastExits()
+	}
+	return sendAlertMessage(e.configManager.Settings, alertMessage)
+}
+
+// Sends an alert message with the configured transport.
+func sendAlertMessage(settings *Settings, alertMessage *AlertMessage) error {
 	if settings.AlertTransport == UNIX_SOCKET_TRANSPORT {
-		if err := e.sendUnixSocketAlert(parsedEvent,
-			settings.SocketFile); err != nil {
-			return err
-		}
+		return sendUnixSocketAlert(alertMessage, settings.SocketFile)
 	}
 	return nil
 }
@@ -432,18 +529,8 @@ func (e *EventMonitor) validateInterval(parsedEvent *ParsedEvent) error {
 	return nil
 }
 
-func (e *EventMonitor) sendUnixSocketAlert(parsedEvent *ParsedEvent,
+func sendUnixSocketAlert(alertMessage *AlertMessage,
 	unixSocketFile string) error {
-	alertMessage := &AlertMessage{
-		Action:      "alert",
-		Rule:        parsedEvent.ruleString,
-		Service:     parsedEvent.processName,
-		Description: parsedEvent.description,
-		// TODO format of date time?
-		Date: time.Now(),
-		// TODO implement.
-		Message_id: 1234,
-	}
 	message, jsonError := json.Marshal(alertMessage)
 	if jsonError != nil {
 		return fmt.Errorf("Error marshalling json: %+v", jsonError)
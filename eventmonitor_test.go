@@ -311,3 +311,22 @@ func (s *EventSuite) TestMonitoringModes(c *C) {
 
 	eventMonitor = EventMonitor{}
 }
+
+func (s *EventSuite) TestReloadLoop(c *C) {
+	monitor := &EventMonitor{}
+	configManager := &ConfigManager{ProcessGroups: map[string]*ProcessGroup{}}
+	control := &Control{ConfigManager: configManager}
+
+	// nothing to stop before the loop has been started
+	c.Check(monitor.Reload(configManager, control), IsNil)
+	c.Assert(monitor.loopDone, NotNil)
+
+	done := monitor.loopDone
+	c.Check(monitor.Reload(configManager, control), IsNil)
+	_, running := <-done
+	c.Check(running, Equals, false)
+
+	monitor.stopLoop()
+	monitor.stopLoop()
+	c.Check(monitor.loopDone, IsNil)
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// gonit records how the programs it runs for a process exit.  The last exit
+// is that of the start program, which is the process itself when it is
+// supervised.  The last command is the last stop or restart program run,
+// with the tail of its output if it failed.  Both are kept in the state of
+// the process, shown by status and sent along with alerts.
+
+// How much of the output of a failing command is kept.
+const MAX_EXIT_OUTPUT = 4096
+
+const (
+	EXIT_PROGRAM_START   = "start"
+	EXIT_PROGRAM_STOP    = "stop"
+	EXIT_PROGRAM_RESTART = "restart"
+)
+
+// How a program of a process exited.  Code is -1 if it was killed by a
+// signal.  Time is in seconds since the epoch.
+type ExitStatus struct {
+	Program    string `json:"program"`
+	Code       int    `json:"code"`
+	Signal     string `json:"signal,omitempty"`
+	CoreDumped bool   `yaml:"core_dumped" json:"core_dumped,omitempty"`
+	Time       int64  `json:"time"`
+	Output     string `json:"output,omitempty"`
+}
+
+// Describes how program exited, keeping output only if it failed.
+func newExitStatus(program string, state *os.ProcessState,
+	output string) *ExitStatus {
+	exit := &ExitStatus{Program: program, Code: -1, Time: time.Now().Unix()}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok {
+		if status.Exited() {
+			exit.Code = status.ExitStatus()
+		} else if status.Signaled() {
+			exit.Signal = signalName(status.Signal())
+			exit.CoreDumped = status.CoreDump()
+		}
+	} else if state.Success() {
+		exit.Code = 0
+	}
+	if !state.Success() {
+		exit.Output = output
+	}
+	return exit
+}
+
+func (e *ExitStatus) String() string {
+	var how string
+	if e.Signal != "" {
+		how = "was killed by " + e.Signal
+		if e.CoreDumped {
+			how += " (core dumped)"
+		}
+	} else {
+		how = fmt.Sprintf("exited with status %d", e.Code)
+	}
+	return fmt.Sprintf("%v program %v at %v", e.Program, how,
+		time.Unix(e.Time, 0).Format(time.RFC3339))
+}
+
+// Returns the last exit of the start program of the process, and of its
+// last stop or restart program.  Either is nil if there is none.
+func (p *Process) LastExits() (*ExitStatus, *ExitStatus) {
+	var exit, command *ExitStatus
+	p.withState(func(state *ProcessState) {
+		exit, command = state.LastExit, state.LastCommand
+	})
+	return exit, command
+}
+
+// Records how a program of the process exited.
+func (p *Process) recordExit(exit *ExitStatus) {
+	Log.Debugf("Process %q %v", p.FullName(), exit)
+	p.withState(func(state *ProcessState) {
+		if exit.Program == EXIT_PROGRAM_START {
+			state.LastExit = exit
+		} else {
+			state.LastCommand = exit
+		}
+	})
+}
+
+// Keeps the last max bytes written to it.
+type tailBuffer struct {
+	sync.Mutex
+	max  int
+	data []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	b.data = append(b.data, p...)
+	if len(b.data) > b.max {
+		b.data = b.data[len(b.data)-b.max:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return string(b.data)
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"bytes"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+type ExitSuite struct {
+	dir string
+}
+
+var _ = Suite(&ExitSuite{})
+
+func (s *ExitSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func runShell(c *C, script string) *os.ProcessState {
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Run()
+	c.Assert(cmd.ProcessState, NotNil)
+	return cmd.ProcessState
+}
+
+func (s *ExitSuite) TestNewExitStatus(c *C) {
+	exit := newExitStatus(EXIT_PROGRAM_STOP, runShell(c, "exit 3"), "oops")
+	c.Check(exit.Program, Equals, "stop")
+	c.Check(exit.Code, Equals, 3)
+	c.Check(exit.Signal, Equals, "")
+	c.Check(exit.Output, Equals, "oops")
+	c.Check(exit.String(), Matches, "stop program exited with status 3 at .*")
+
+	exit = newExitStatus(EXIT_PROGRAM_START, runShell(c, "kill -TERM $$"), "")
+	c.Check(exit.Code, Equals, -1)
+	c.Check(exit.Signal, Equals, "SIGTERM")
+	c.Check(exit.String(), Matches, "start program was killed by SIGTERM at .*")
+
+	// output is only kept for failures
+	exit = newExitStatus(EXIT_PROGRAM_STOP, runShell(c, "true"), "fine")
+	c.Check(exit.Code, Equals, 0)
+	c.Check(exit.Output, Equals, "")
+}
+
+func (s *ExitSuite) TestStopCommand(c *C) {
+	process := &Process{
+		Name:   "exit-stop",
+		Stop:   "echo going down; echo failed; exit 2",
+		Shell:  true,
+		Stdout: filepath.Join(s.dir, "stop.log"),
+	}
+	control := &Control{}
+	control.State(process)
+
+	c.Check(process.StopProcess(), NotNil)
+	exit, command := process.LastExits()
+	c.Check(exit, IsNil)
+	c.Assert(command, NotNil)
+	c.Check(command.Program, Equals, "stop")
+	c.Check(command.Code, Equals, 2)
+	c.Check(command.Output, Equals, "going down\nfailed\n")
+
+	// the output still goes to the output files
+	data, err := ioutil.ReadFile(process.Stdout)
+	c.Check(err, IsNil)
+	c.Check(string(data), Equals, "going down\nfailed\n")
+}
+
+func (s *ExitSuite) TestStopCommandBackground(c *C) {
+	pidfile := filepath.Join(s.dir, "background.pid")
+	process := &Process{
+		Name: "exit-background",
+		Stop: "sleep 60 & echo $! > " + pidfile + "; echo stopping; " +
+			"echo oops >&2; exit 1",
+		Shell:       true,
+		Stdout:      filepath.Join(s.dir, "stop.log"),
+		Stderr:      filepath.Join(s.dir, "stop.log"),
+		StopTimeout: "10s",
+	}
+	control := &Control{}
+	control.State(process)
+
+	// isn't held up by the child left running
+	started := time.Now()
+	c.Check(process.StopProcess(), NotNil)
+	c.Check(time.Since(started) < 5*time.Second, Equals, true)
+	pid, err := ReadPidFile(pidfile)
+	c.Assert(err, IsNil)
+	syscall.Kill(pid, syscall.SIGKILL)
+
+	_, command := process.LastExits()
+	c.Assert(command, NotNil)
+	c.Check(command.Output, Equals, "stopping\noops\n")
+
+	// without output files
+	process.Stdout, process.Stderr = "", ""
+	c.Check(process.StopProcess(), NotNil)
+	pid, err = ReadPidFile(pidfile)
+	c.Assert(err, IsNil)
+	syscall.Kill(pid, syscall.SIGKILL)
+	_, command = process.LastExits()
+	c.Check(command.Output, Equals, "stopping\noops\n")
+}
+
+func (s *ExitSuite) TestStopCommandTimeout(c *C) {
+	process := &Process{
+		Name:        "exit-timeout",
+		Stop:        "echo waiting; sleep 60",
+		Shell:       true,
+		StopTimeout: "200ms",
+	}
+	control := &Control{}
+	control.State(process)
+
+	c.Check(process.StopProcess(), ErrorMatches,
+		"stop program timed out after 200ms")
+	_, command := process.LastExits()
+	c.Assert(command, NotNil)
+	c.Check(command.Signal, Equals, "SIGKILL")
+	c.Check(command.Output, Equals, "waiting\n")
+}
+
+func (s *ExitSuite) TestStartExit(c *C) {
+	process := &Process{Name: "exit-start", Start: "exit 4", Shell: true}
+	control := &Control{}
+	control.State(process)
+
+	_, err := process.StartProcess()
+	c.Assert(err, IsNil)
+	var exit *ExitStatus
+	for i := 0; i < 100 && exit == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		exit, _ = process.LastExits()
+	}
+	c.Assert(exit, NotNil)
+	c.Check(exit.Program, Equals, "start")
+	c.Check(exit.Code, Equals, 4)
+}
+
+func (s *ExitSuite) TestStatus(c *C) {
+	status := &ProcessStatus{}
+	status.Summary.Name = "web/worker"
+	status.Summary.ControlState.LastExit = &ExitStatus{Program: "start",
+		Signal: "SIGSEGV", CoreDumped: true, Code: -1}
+	status.Summary.ControlState.LastCommand = &ExitStatus{Program: "stop",
+		Code: 1, Output: "no such pid\n"}
+	var out bytes.Buffer
+	status.Print(&out)
+	c.Check(out.String(), Matches, `(?s).*last exit +start program was killed `+
+		`by SIGSEGV \(core dumped\) at .*\n  last command +stop program exited `+
+		`with status 1 at .*\n  last command output +"no such pid"\n.*`)
+}
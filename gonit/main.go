@@ -13,6 +13,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"syscall"
 )
 
@@ -25,6 +26,8 @@ var (
 	poll       int
 	group      bool
 	foreground bool
+	async      bool
+	timeout    string
 	version    bool
 
 	// internal
@@ -45,6 +48,16 @@ func main() {
 
 	configManager := &gonit.ConfigManager{}
 
+	if args := flag.Args(); len(args) > 0 && args[0] == "check-config" {
+		checkConfig(configManager)
+		return
+	}
+
+	if args := flag.Args(); len(args) > 0 && args[0] == "graph" {
+		showGraph(configManager)
+		return
+	}
+
 	if config != "" {
 		err := configManager.LoadConfig(config)
 		if err != nil {
@@ -104,6 +117,8 @@ func parseFlags() {
 	flag.StringVar(&rpcUrl, "s", "", "RPC server URL")
 	flag.IntVar(&poll, "d", 0, "Run as a daemon with duration")
 	flag.StringVar(&logLevel, "l", "", "Log level")
+	flag.BoolVar(&async, "a", false, "Run the action as a job, do not wait")
+	flag.StringVar(&timeout, "t", "", "How long to wait for a job, e.g. 30s")
 
 	const named = "the named process or group"
 	const all = "all processes"
@@ -127,6 +142,12 @@ func parseFlags() {
 		{"status name", "Only print short status info for", named},
 		{"summary", "Print short status information for", all},
 		{"reload", "Reload", "config files"},
+		{"check-config", "Check", "config files and print every error"},
+		{"graph", "Print", "the process dependency graph in DOT format"},
+		{"jobs", "List", "the running jobs"},
+		{"job id", "Print the progress of", "the job"},
+		{"wait id", "Wait for and print", "the job"},
+		{"cancel id", "Cancel", "the job"},
 	}
 
 	flag.Usage = func() {
@@ -141,6 +162,8 @@ func parseFlags() {
 			fmt.Printf("  %-20s - %s %s\n", action.usage,
 				action.description, action.what)
 		}
+		fmt.Println("Process names may be qualified by their group as " +
+			"group/name.")
 	}
 
 	flag.Parse()
@@ -180,12 +203,42 @@ func runCommand(cmd, arg string) {
 		defer rpc.Close()
 		client = gonit.NewRemoteClient(rpc, api)
 	} else {
+		if async {
+			// the job would not outlive this process
+			log.Fatal("Jobs can only be submitted to a running daemon")
+		}
 		client = gonit.NewLocalClient(api)
 	}
 
-	method, name := gonit.RpcArgs(cmd, arg, group)
+	var reply interface{}
+	var err error
 
-	reply, err := client.Call(method, name)
+	switch cmd {
+	case "jobs":
+		reply, err = client.Call("Jobs", "")
+	case "job", "wait", "cancel":
+		var id int
+		if id, err = strconv.Atoi(arg); err != nil {
+			log.Fatalf("invalid job id %q", arg)
+		}
+		switch cmd {
+		case "job":
+			reply, err = client.Call("Job", id)
+		case "wait":
+			reply, err = client.Call("WaitJob",
+				gonit.JobWait{Id: id, Timeout: timeout})
+		case "cancel":
+			reply, err = client.Call("CancelJob", id)
+		}
+	default:
+		method, name := gonit.RpcArgs(cmd, arg, group)
+		if async {
+			reply, err = client.Call("SubmitJob",
+				gonit.JobRequest{Method: method, Name: name})
+		} else {
+			reply, err = client.Call(method, name)
+		}
+	}
 
 	if err != nil {
 		log.Fatal(err)
@@ -198,8 +251,11 @@ func runCommand(cmd, arg string) {
 	}
 }
 
-func reload() {
-	log.Printf("XXX reload config")
+// Reopens the output files, e.g. after logrotate moved them away.  The
+// config is only reloaded when asked to with 'gonit reload'.
+func reopenOutputs() {
+	log.Printf("Reopen output files")
+	gonit.ReopenOutputs()
 }
 
 func wakeup() {
@@ -239,7 +295,7 @@ func start() {
 var handlers = map[syscall.Signal]func(){
 	syscall.SIGTERM: shutdown,
 	syscall.SIGINT:  shutdown,
-	syscall.SIGHUP:  reload,
+	syscall.SIGHUP:  reopenOutputs,
 	syscall.SIGUSR1: wakeup,
 }
 
@@ -295,6 +351,41 @@ func createEventMonitor(control *gonit.Control,
 	control.RegisterEventMonitor(eventMonitor)
 }
 
+// Report every problem with the config files, without running any actions.
+func checkConfig(configManager *gonit.ConfigManager) {
+	if logLevel == "" {
+		logLevel = "warn"
+	}
+	logging := &gonit.LoggerConfig{Level: logLevel}
+	if err := logging.Init(); err != nil {
+		log.Fatal(err)
+	}
+
+	errs := configManager.CheckConfig(config)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) != 0 {
+		fmt.Fprintf(os.Stderr, "%d error(s) found in '%s'\n", len(errs), config)
+		os.Exit(1)
+	}
+	fmt.Printf("Config '%s' OK\n", config)
+}
+
+func showGraph(configManager *gonit.ConfigManager) {
+	if logLevel == "" {
+		logLevel = "warn"
+	}
+	logging := &gonit.LoggerConfig{Level: logLevel}
+	if err := logging.Init(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := configManager.WriteDependencyDot(config, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
 func showVersion() {
 	fmt.Printf("Gonit version %s\n", gonit.VERSION)
 }
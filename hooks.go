@@ -0,0 +1,172 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// Hooks are commands run around starting and stopping a process, e.g. to
+// create its run directory or deregister it from service discovery:
+//
+//   web:
+//     hooks:
+//       pre_start:
+//         - command: mkdir -p /var/vcap/sys/run/web
+//           uid: root
+//       pre_stop:
+//         - command: /var/vcap/jobs/web/bin/deregister
+//           timeout: 10s
+//
+// Hooks run one at a time, in order, with the env, dir, chroot, umask, output
+// files and credentials of the process, unless they set their own uid or
+// gid.  They don't join its cgroup or get its limits, nice and affinity, and
+// their output goes to the files directly even if gonit manages the output
+// of the process.  A hook
+// that fails or times out is recorded as an error of the action.  A failing
+// pre_start hook also stops the process from being started, and the hooks
+// after it from running.
+
+const DEFAULT_HOOK_TIMEOUT = "30s"
+
+const (
+	HOOK_PRE_START  = "pre_start"
+	HOOK_POST_START = "post_start"
+	HOOK_PRE_STOP   = "pre_stop"
+	HOOK_POST_STOP  = "post_stop"
+)
+
+// The hooks of a process.
+type Hooks struct {
+	PreStart  []*Hook `yaml:"pre_start"`
+	PostStart []*Hook `yaml:"post_start"`
+	PreStop   []*Hook `yaml:"pre_stop"`
+	PostStop  []*Hook `yaml:"post_stop"`
+}
+
+// A command run around a process action.
+type Hook struct {
+	Command string
+	Timeout string
+	Uid     string
+	Gid     string
+}
+
+// Returns the hooks of a process by when they run.
+func (p *Process) hooks(when string) []*Hook {
+	if p.Hooks == nil {
+		return nil
+	}
+	switch when {
+	case HOOK_PRE_START:
+		return p.Hooks.PreStart
+	case HOOK_POST_START:
+		return p.Hooks.PostStart
+	case HOOK_PRE_STOP:
+		return p.Hooks.PreStop
+	case HOOK_POST_STOP:
+		return p.Hooks.PostStop
+	}
+	return nil
+}
+
+// Returns all hooks of a process, with a name for each.
+func (p *Process) allHooks() ([]string, []*Hook) {
+	names, hooks := []string{}, []*Hook{}
+	for _, when := range []string{HOOK_PRE_START, HOOK_POST_START,
+		HOOK_PRE_STOP, HOOK_POST_STOP} {
+		for i, hook := range p.hooks(when) {
+			names = append(names, fmt.Sprintf("%v hook %v", when, i+1))
+			hooks = append(hooks, hook)
+		}
+	}
+	return names, hooks
+}
+
+// Checks the hooks of a process.  Their commands are checked along with the
+// other programs of the process.
+func (p *Process) validateHooks() error {
+	errs := ConfigErrors{}
+	names, hooks := p.allHooks()
+	for i, hook := range hooks {
+		what := fmt.Sprintf("Process %v %v", p.FullName(), names[i])
+		if hook == nil || hook.Command == "" {
+			errs.add(fmt.Errorf("%v has no command.", what))
+			continue
+		}
+		errs.add(validateTimeout(what+" timeout", hook.Timeout))
+		if hook.Uid != "" {
+			if _, err := LookupUser(hook.Uid); err != nil {
+				errs.add(fmt.Errorf("%v has an unknown uid '%v'.", what, hook.Uid))
+			}
+		}
+		if hook.Gid != "" {
+			if _, err := LookupGroupId(hook.Gid); err != nil {
+				errs.add(fmt.Errorf("%v has an unknown gid '%v'.", what, hook.Gid))
+			}
+		}
+	}
+	return errs.errOrNil()
+}
+
+// Returns a copy of the process to run the hook as, with the credentials of
+// the hook and without the settings that only apply to the process itself.
+func (h *Hook) process(p *Process) *Process {
+	process := *p
+	if h.Uid != "" {
+		process.Uid = h.Uid
+	}
+	if h.Gid != "" {
+		process.Gid = h.Gid
+	}
+	process.Cgroup = nil
+	process.Output = nil
+	process.Limits = nil
+	process.Nice = 0
+	process.Affinity = nil
+	return &process
+}
+
+func (h *Hook) timeout() time.Duration {
+	return parseTimeout(h.Timeout, DEFAULT_HOOK_TIMEOUT)
+}
+
+// Runs a hook and waits for it to exit.
+func (h *Hook) run(p *Process) error {
+	cmd, err := h.process(p).Spawn(h.Command)
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	timeout := h.timeout()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		// hooks run in their own session, kill anything they started too
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+}
+
+// Runs the hooks of a process for when, recording each as a step of the
+// action.  Returns false if a hook failed, after which the rest are skipped.
+func (c *Control) runHooks(process *Process, when string,
+	action *ControlAction) bool {
+	for _, hook := range process.hooks(when) {
+		action.step(process, "running %v hook '%v'", when, hook.Command)
+		if err := hook.run(process); err != nil {
+			action.fail(fmt.Errorf("process %q %v hook '%v' failed: %v",
+				process.FullName(), when, hook.Command, err))
+			return false
+		}
+	}
+	return true
+}
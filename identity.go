@@ -0,0 +1,131 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"fmt"
+	"github.com/cloudfoundry/gosigar"
+	"strings"
+)
+
+// A pidfile can outlive its process, e.g. across a reboot, and its pid can
+// then belong to an unrelated process.  So gonit records the start time and
+// executable of a process when it starts or first finds it, and only takes a
+// pid read from a pidfile to be the process while they still match.  The
+// executable is only compared where the start time is unknown, as processes
+// often exec another program right after they start, e.g. from a wrapper
+// script.  The records are persisted with the rest of the process state.  A
+// pidfile with a pid other than the recorded one was written since, and its
+// process is recorded instead.
+
+// Start times from sigar are whole seconds, and may be off by one between
+// runs of gonit, as the boot time they are computed from is.
+const IDENTITY_START_TIME_SLACK = 1000
+
+// What a running process was when gonit recorded it.
+type ProcessIdentity struct {
+	Pid       int
+	StartTime uint64 `yaml:"start_time"`
+	Exe       string
+}
+
+// Returns the identity of the running process with the given pid.
+func getProcessIdentity(pid int) (*ProcessIdentity, error) {
+	procTime := sigar.ProcTime{}
+	if err := procTime.Get(pid); err != nil {
+		return nil, err
+	}
+	identity := &ProcessIdentity{Pid: pid, StartTime: procTime.StartTime}
+	// the exe of processes of other users can't be read unless gonit is root
+	exe := sigar.ProcExe{}
+	if err := exe.Get(pid); err == nil {
+		identity.Exe = strings.TrimSpace(exe.Name)
+		// the binary may have been upgraded since the process started
+		if strings.HasSuffix(identity.Exe, " (deleted)") {
+			identity.Exe = identity.Exe[:len(identity.Exe)-len(" (deleted)")]
+		}
+	}
+	return identity, nil
+}
+
+// Returns why other, with the same pid, is not the process i was recorded
+// from, or nil if it is.
+func (i *ProcessIdentity) mismatch(other *ProcessIdentity) error {
+	if i.StartTime != 0 && other.StartTime != 0 {
+		slack := int64(IDENTITY_START_TIME_SLACK)
+		if diff := int64(other.StartTime - i.StartTime); diff > slack ||
+			diff < -slack {
+			return fmt.Errorf("started at %d, not %d", other.StartTime,
+				i.StartTime)
+		}
+	} else if i.Exe != "" && other.Exe != "" && i.Exe != other.Exe {
+		return fmt.Errorf("runs %v, not %v", other.Exe, i.Exe)
+	}
+	return nil
+}
+
+// Returns the recorded identity of the process, nil if there is none.
+func (p *Process) Identity() *ProcessIdentity {
+	var identity *ProcessIdentity
+	p.withState(func(state *ProcessState) {
+		identity = state.Identity
+	})
+	return identity
+}
+
+// Replaces the recorded identity of the process.
+func (p *Process) setIdentity(identity *ProcessIdentity) {
+	p.withState(func(state *ProcessState) {
+		state.Identity = identity
+		state.identityChanged = true
+		state.stalePid = 0
+	})
+}
+
+// Records the identity of the process running with pid.
+func (p *Process) recordIdentity(pid int) {
+	identity, err := getProcessIdentity(pid)
+	if err != nil {
+		Log.Debugf("Could not record identity of process %q, pid=%d: %v",
+			p.FullName(), pid, err)
+		return
+	}
+	Log.Debugf("Recorded identity of process %q: %+v", p.FullName(), identity)
+	p.setIdentity(identity)
+}
+
+// Checks that pid, read from the pidfile, is still the process gonit
+// recorded.  An unrecorded pid that is running is recorded.
+func (p *Process) checkIdentity(pid int) error {
+	current, err := getProcessIdentity(pid)
+	if err != nil {
+		// not running, nothing to check
+		return nil
+	}
+	recorded := p.Identity()
+	if recorded == nil || recorded.Pid != pid {
+		Log.Debugf("Recorded identity of process %q: %+v", p.FullName(),
+			current)
+		p.setIdentity(current)
+		return nil
+	}
+	if err := recorded.mismatch(current); err != nil {
+		name := p.FullName()
+		warn := false
+		p.withState(func(state *ProcessState) {
+			warn, state.stalePid = state.stalePid != pid, pid
+		})
+		if warn {
+			Log.Warnf("Pidfile %v of process %q is stale, pid %d %v",
+				p.Pidfile, name, pid, err)
+		}
+		return fmt.Errorf("process %q is not running, pidfile %v is stale",
+			name, p.Pidfile)
+	}
+	if current.Exe != recorded.Exe && current.Exe != "" {
+		Log.Debugf("Process %q, pid=%d, now runs %v", p.FullName(), pid,
+			current.Exe)
+		p.setIdentity(current)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	. "launchpad.net/gocheck"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+type IdentitySuite struct {
+	dir string
+}
+
+var _ = Suite(&IdentitySuite{})
+
+func (s *IdentitySuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func startSleep(c *C) *exec.Cmd {
+	cmd := exec.Command("sleep", "60")
+	c.Assert(cmd.Start(), IsNil)
+	return cmd
+}
+
+func stopSleep(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+	cmd.Wait()
+}
+
+func (s *IdentitySuite) TestStalePidfile(c *C) {
+	process := &Process{Name: "identity-stale",
+		Pidfile: filepath.Join(s.dir, "sleep.pid")}
+	control := &Control{}
+	control.State(process)
+	cmd := startSleep(c)
+	defer stopSleep(cmd)
+	c.Assert(WritePidFile(cmd.Process.Pid, process.Pidfile), IsNil)
+
+	// the pid is recorded the first time it is seen
+	c.Check(process.IsRunning(), Equals, true)
+	identity := process.Identity()
+	c.Assert(identity, NotNil)
+	c.Check(identity.Pid, Equals, cmd.Process.Pid)
+	c.Check(identity.StartTime, Not(Equals), uint64(0))
+	c.Check(strings.HasSuffix(identity.Exe, "sleep"), Equals, true)
+
+	// as if the pid was recorded before a reboot
+	process.setIdentity(&ProcessIdentity{Pid: identity.Pid,
+		StartTime: identity.StartTime - 3600*1000, Exe: identity.Exe})
+	c.Check(process.IsRunning(), Equals, false)
+	_, err := process.Pid()
+	c.Check(err, ErrorMatches, ".* pidfile .* is stale")
+
+	// a pidfile written since is taken as it is
+	other := startSleep(c)
+	defer stopSleep(other)
+	c.Assert(WritePidFile(other.Process.Pid, process.Pidfile), IsNil)
+	c.Check(process.IsRunning(), Equals, true)
+	c.Check(process.Identity().Pid, Equals, other.Process.Pid)
+}
+
+func (s *IdentitySuite) TestMismatch(c *C) {
+	recorded := &ProcessIdentity{Pid: 10, StartTime: 50000, Exe: "/bin/sh"}
+
+	c.Check(recorded.mismatch(&ProcessIdentity{Pid: 10, StartTime: 51000,
+		Exe: "/bin/sleep"}), IsNil)
+	c.Check(recorded.mismatch(&ProcessIdentity{Pid: 10, StartTime: 52000,
+		Exe: "/bin/sh"}), ErrorMatches, "started at 52000, not 50000")
+
+	// the exe is only compared when start times are unknown
+	recorded.StartTime = 0
+	c.Check(recorded.mismatch(&ProcessIdentity{Pid: 10, StartTime: 52000,
+		Exe: "/bin/sh"}), IsNil)
+	c.Check(recorded.mismatch(&ProcessIdentity{Pid: 10, StartTime: 52000,
+		Exe: "/bin/sleep"}), ErrorMatches, "runs /bin/sleep, not /bin/sh")
+}
+
+func (s *IdentitySuite) TestPersistIdentity(c *C) {
+	configManager := &ConfigManager{
+		Settings: &Settings{PersistFile: filepath.Join(s.dir, "state.yml")},
+	}
+	control := &Control{ConfigManager: configManager}
+	process := &Process{Name: "identity-persist"}
+	control.Config().AddProcess("web", process)
+	control.State(process)
+
+	identity := &ProcessIdentity{Pid: 123, StartTime: 50000, Exe: "/bin/sh"}
+	process.setIdentity(identity)
+	c.Assert(control.PersistStates(control.States), IsNil)
+
+	control.States = nil
+	c.Assert(control.LoadPersistState(), IsNil)
+	c.Check(process.Identity(), DeepEquals, identity)
+}
+
+func (s *IdentitySuite) TestPersistMonitoredIdentity(c *C) {
+	configManager := &ConfigManager{
+		Settings: &Settings{PersistFile: filepath.Join(s.dir, "state.yml")},
+	}
+	control := &Control{ConfigManager: configManager}
+	process := &Process{Name: "identity-monitored",
+		Pidfile:     filepath.Join(s.dir, "sleep.pid"),
+		MonitorMode: MONITOR_MODE_ACTIVE}
+	control.Config().AddProcess("web", process)
+	cmd := startSleep(c)
+	defer stopSleep(cmd)
+	c.Assert(WritePidFile(cmd.Process.Pid, process.Pidfile), IsNil)
+
+	// found running by the watcher, without gonit starting it
+	watcher := &Watcher{Control: control}
+	watcher.checkProcess(process)
+	identity := process.Identity()
+	c.Assert(identity, NotNil)
+
+	control.States = nil
+	c.Assert(control.LoadPersistState(), IsNil)
+	c.Check(process.Identity(), DeepEquals, identity)
+}
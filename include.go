@@ -0,0 +1,281 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"launchpad.net/goyaml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Config files may pull in other yaml files with an 'include' key, e.g.:
+//
+//   include:
+//     - common/events.yml
+//     - conf.d
+//     - workers/*.yml
+//
+// Relative paths are resolved against the directory of the including file,
+// globs are expanded and directories are searched recursively for *.yml
+// files.  Included documents are merged into the including one, which wins
+// on conflicting keys.
+//
+// Every string value may refer to environment variables as ${VAR} or
+// ${VAR:-default}.  A literal '$' is written as '$$'.  The env of processes
+// is the exception: it is expanded when a program is run, see env.go.
+
+const INCLUDE_KEY = "include"
+
+type yamlMap map[interface{}]interface{}
+
+// Reads a config file along with its includes and unmarshals the merged
+// result into out.  Keys and values that don't fit out are left out of it and
+// collected in c.schemaErrors.
+func (c *ConfigManager) loadYaml(path string, out interface{}) error {
+	doc, err := c.readYaml(path, nil, reflect.TypeOf(out).Elem())
+	if err != nil {
+		return err
+	}
+	b, err := goyaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if err := goyaml.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	return nil
+}
+
+// Returns a description of the file and the files that included it.
+func includeChainString(chain []string) string {
+	if len(chain) == 1 {
+		return chain[0]
+	}
+	parents := make([]string, len(chain)-1)
+	for i := range parents {
+		parents[i] = chain[len(chain)-2-i]
+	}
+	return fmt.Sprintf("%v (included from %v)", chain[len(chain)-1],
+		strings.Join(parents, " <- "))
+}
+
+// Reads, interpolates and merges a yaml file and everything it includes.
+// The chain holds the files that led to this one.
+func (c *ConfigManager) readYaml(path string, chain []string,
+	schema reflect.Type) (yamlMap, error) {
+	for _, parent := range chain {
+		if parent == path {
+			return nil, fmt.Errorf("%v: include cycle",
+				includeChainString(append(chain, path)))
+		}
+	}
+	chain = append(chain, path)
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if len(chain) == 1 {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%v: %v", includeChainString(chain), err)
+	}
+	doc := yamlMap{}
+	if err := goyaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%v: %v", includeChainString(chain), err)
+	}
+	c.schemaErrors.add(checkSchema(path, b, doc, schema))
+	if err := interpolateYaml(doc, nil); err != nil {
+		return nil, fmt.Errorf("%v: %v", includeChainString(chain), err)
+	}
+
+	includes, err := includePaths(path, doc[INCLUDE_KEY])
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", includeChainString(chain), err)
+	}
+	delete(doc, INCLUDE_KEY)
+
+	merged := yamlMap{}
+	for _, include := range includes {
+		included, err := c.readYaml(include, chain, schema)
+		if err != nil {
+			return nil, err
+		}
+		mergeYaml(merged, included)
+		Log.Infof("Loaded included config file '%v'", include)
+	}
+	mergeYaml(merged, doc)
+	return merged, nil
+}
+
+// Given the value of an include key, returns the files it refers to.
+func includePaths(path string, value interface{}) ([]string, error) {
+	var patterns []string
+	switch value := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		patterns = []string{value}
+	case []interface{}:
+		for _, pattern := range value {
+			s, ok := pattern.(string)
+			if !ok {
+				return nil, fmt.Errorf("Invalid include '%v'.", pattern)
+			}
+			patterns = append(patterns, s)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid include '%v'.", value)
+	}
+
+	paths := []string{}
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("Include '%v' does not exist.", pattern)
+		}
+		for _, match := range matches {
+			files, err := yamlFilesIn(match)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, files...)
+		}
+	}
+	return paths, nil
+}
+
+// Returns path if it is a file, or all *.yml files below it if it is a
+// directory.
+func yamlFilesIn(path string) ([]string, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fileInfo.IsDir() {
+		return []string{path}, nil
+	}
+	files := []string{}
+	err = filepath.Walk(path, func(name string, info os.FileInfo,
+		err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(name, ".yml") {
+			files = append(files, name)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// Merges src into dst.  Nested maps are merged, anything else in src
+// replaces what is in dst.
+func mergeYaml(dst, src yamlMap) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(yamlMap)
+		dstMap, dstIsMap := dst[key].(yamlMap)
+		if srcIsMap && dstIsMap {
+			mergeYaml(dstMap, srcMap)
+		} else {
+			dst[key] = value
+		}
+	}
+}
+
+// Expands environment variables in every string value of a yaml document,
+// found at path in the config.
+func interpolateYaml(doc yamlMap, path []string) error {
+	for key, value := range doc {
+		keyPath := append(append([]string{}, path...), fmt.Sprint(key))
+		if isSpawnTimeEnv(keyPath) {
+			continue
+		}
+		expanded, err := interpolateValue(value, keyPath)
+		if err != nil {
+			return fmt.Errorf("%v: %v", key, err)
+		}
+		doc[key] = expanded
+	}
+	return nil
+}
+
+// Returns whether path is the env of a process, which is expanded when the
+// process is started instead.
+func isSpawnTimeEnv(path []string) bool {
+	return len(path) == 3 && path[0] == "processes" && path[2] == "env"
+}
+
+func interpolateValue(value interface{}, path []string) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		return Interpolate(value, os.Getenv)
+	case yamlMap:
+		return value, interpolateYaml(value, path)
+	case map[interface{}]interface{}:
+		// nested maps may come back unnamed, convert so merging finds them.
+		doc := yamlMap(value)
+		return doc, interpolateYaml(doc, path)
+	case []interface{}:
+		for i, item := range value {
+			expanded, err := interpolateValue(item, path)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = expanded
+		}
+	}
+	return value, nil
+}
+
+// Replaces ${VAR} and ${VAR:-default} in s using lookup.  It is an error to
+// refer to an empty or unset variable that has no default.
+func Interpolate(s string, lookup func(string) string) (string, error) {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			out = append(out, s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			out = append(out, '$')
+			i++
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("Unterminated variable in '%v'.", s)
+			}
+			expr := s[i+2 : i+end]
+			name, def, hasDefault := expr, "", false
+			if sep := strings.Index(expr, ":-"); sep >= 0 {
+				name, def, hasDefault = expr[:sep], expr[sep+2:], true
+			}
+			if name == "" {
+				return "", fmt.Errorf("Empty variable name in '%v'.", s)
+			}
+			value := lookup(name)
+			if value == "" {
+				if !hasDefault {
+					return "", fmt.Errorf("Variable '%v' is not set.", name)
+				}
+				value = def
+			}
+			out = append(out, value...)
+			i += end
+		default:
+			out = append(out, s[i])
+		}
+	}
+	return string(out), nil
+}
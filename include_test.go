@@ -0,0 +1,127 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type IncludeSuite struct {
+	dir string
+}
+
+var _ = Suite(&IncludeSuite{})
+
+func (s *IncludeSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *IncludeSuite) writeFile(c *C, name string, content string) string {
+	path := filepath.Join(s.dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		c.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		c.Fatal(err)
+	}
+	return path
+}
+
+func (s *IncludeSuite) TestInterpolate(c *C) {
+	env := map[string]string{"HOME": "/home/vcap", "EMPTY": ""}
+	lookup := func(name string) string { return env[name] }
+
+	value, err := Interpolate("${HOME}/run/${NAME:-web}.pid", lookup)
+	c.Check(err, IsNil)
+	c.Check(value, Equals, "/home/vcap/run/web.pid")
+
+	value, err = Interpolate("${EMPTY:-default} costs $$5", lookup)
+	c.Check(err, IsNil)
+	c.Check(value, Equals, "default costs $5")
+
+	_, err = Interpolate("${NOPE}", lookup)
+	c.Check(err, ErrorMatches, "Variable 'NOPE' is not set.")
+
+	_, err = Interpolate("${HOME", lookup)
+	c.Check(err, ErrorMatches, "Unterminated variable.*")
+}
+
+func (s *IncludeSuite) TestIncludes(c *C) {
+	os.Setenv("GONIT_TEST_RUN_DIR", "/var/run/test")
+	defer os.Setenv("GONIT_TEST_RUN_DIR", "")
+
+	path := s.writeFile(c, "web-gonit.yml", `
+include:
+  - common/events.yml
+  - conf.d
+processes:
+  web:
+    description: web server
+    pidfile: ${GONIT_TEST_RUN_DIR}/web.pid
+    start: /bin/sleep 60
+`)
+	s.writeFile(c, "common/events.yml", `
+events:
+  memory_over_5:
+    description: The memory for a process is too high.
+    rule: memory_used > 5mb
+`)
+	s.writeFile(c, "conf.d/workers/worker.yml", `
+processes:
+  worker:
+    description: worker
+    pidfile: ${WORKER_PIDFILE:-/tmp/worker.pid}
+    start: /bin/sleep 60
+`)
+	s.writeFile(c, "conf.d/web.yml", `
+processes:
+  web:
+    description: overridden by the including file
+    dir: /var/vcap
+`)
+
+	configManager := &ConfigManager{}
+	err := configManager.LoadConfig(path)
+	c.Assert(err, IsNil)
+
+	pg := configManager.ProcessGroups["web"]
+	c.Assert(pg, NotNil)
+	c.Check(len(pg.Processes), Equals, 2)
+	c.Check(pg.Processes["web"].Pidfile, Equals, "/var/run/test/web.pid")
+	c.Check(pg.Processes["web"].Description, Equals, "web server")
+	c.Check(pg.Processes["web"].Dir, Equals, "/var/vcap")
+	c.Check(pg.Processes["worker"].Pidfile, Equals, "/tmp/worker.pid")
+	c.Check(pg.EventByName("memory_over_5"), NotNil)
+}
+
+func (s *IncludeSuite) TestIncludeErrors(c *C) {
+	path := s.writeFile(c, "web-gonit.yml", "include: common.yml\n")
+	common := s.writeFile(c, "common.yml", "include: [events.yml]\n")
+	events := s.writeFile(c, "events.yml", `
+events:
+  bad:
+    rule: ${GONIT_TEST_UNSET_VAR} > 5mb
+`)
+
+	configManager := &ConfigManager{}
+	err := configManager.LoadConfig(path)
+	c.Assert(err, NotNil)
+	c.Check(strings.HasPrefix(err.Error(), events+" (included from "+common+
+		" <- "+path+"): "), Equals, true)
+	c.Check(strings.Contains(err.Error(), "GONIT_TEST_UNSET_VAR"), Equals, true)
+
+	s.writeFile(c, "events.yml", "include: web-gonit.yml\n")
+	err = configManager.LoadConfig(path)
+	c.Assert(err, NotNil)
+	c.Check(strings.HasSuffix(err.Error(), "include cycle"), Equals, true)
+
+	s.writeFile(c, "events.yml", "include: missing.yml\n")
+	err = configManager.LoadConfig(path)
+	c.Assert(err, NotNil)
+	c.Check(strings.Contains(err.Error(), "missing.yml' does not exist"),
+		Equals, true)
+}
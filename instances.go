@@ -0,0 +1,179 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// A process with 'instances: N' is a template for N identical processes,
+// named name@0 to name@N-1.  Its pidfile, start, stop, restart, stdout,
+// stderr and env may refer to {{.Index}} and {{.Name}} of each instance, e.g.:
+//
+//   worker:
+//     instances: 3
+//     pidfile: /var/vcap/sys/run/{{.Name}}.pid
+//     start: /var/vcap/jobs/worker/bin/worker --index {{.Index}}
+//
+// Depending on a templated process means depending on all of its instances.
+// 'instances: 0' leaves no instances at all, so a reload with it stops the
+// instances that were running.
+
+const INSTANCE_SEPARATOR = "@"
+
+// The data templated fields of an instance are executed with.
+type Instance struct {
+	Index int
+	Name  string
+}
+
+// Returns the name of instance index of the named process.
+func instanceName(name string, index int) string {
+	return name + INSTANCE_SEPARATOR + strconv.Itoa(index)
+}
+
+// Replaces every process that has instances with its instances.
+func (c *ConfigManager) expandInstances() error {
+	errs := ConfigErrors{}
+	instances := map[string][]string{}
+	for _, pg := range c.sortedGroups() {
+		for _, process := range pg.sortedProcesses() {
+			if process.Instances == nil {
+				continue
+			}
+			if *process.Instances < 0 {
+				errs.add(fmt.Errorf("Process %v instances must not be negative.",
+					process.FullName()))
+				continue
+			}
+			delete(pg.Processes, process.Name)
+			names := []string{}
+			for i := 0; i < *process.Instances; i++ {
+				instance, err := process.newInstance(i)
+				if err != nil {
+					errs.add(err)
+					continue
+				}
+				if _, exists := pg.Processes[instance.Name]; exists {
+					errs.add(fmt.Errorf("Process %v instance %v has the same "+
+						"name as another process.", process.FullName(),
+						instance.FullName()))
+					continue
+				}
+				pg.Processes[instance.Name] = instance
+				names = append(names, instance.FullName())
+			}
+			instances[process.FullName()] = names
+		}
+	}
+	if len(instances) != 0 {
+		c.expandDependencies(instances)
+	}
+	return errs.errOrNil()
+}
+
+// Returns a copy of a templated process for instance index.  The copy shares
+// nothing with the template, so instances can be changed on their own.
+func (p *Process) newInstance(index int) (*Process, error) {
+	instance := deepCopy(reflect.ValueOf(p)).Interface().(*Process)
+	instance.child = nil
+	instance.control = nil
+	instance.Name = instanceName(p.Name, index)
+	data := &Instance{Index: index, Name: instance.Name}
+	fields := []*string{&instance.Pidfile, &instance.Start, &instance.Stop,
+		&instance.Restart, &instance.Stdout, &instance.Stderr}
+	for i := range instance.Env {
+		fields = append(fields, &instance.Env[i])
+	}
+	for _, field := range fields {
+		expanded, err := executeTemplate(*field, data)
+		if err != nil {
+			return nil, fmt.Errorf("Process %v: %v", instance.FullName(), err)
+		}
+		*field = expanded
+	}
+	return instance, nil
+}
+
+// Returns a copy of value with new copies of everything its pointers, slices
+// and maps refer to.  Unexported struct fields are copied as they are.
+func deepCopy(value reflect.Value) reflect.Value {
+	copied := reflect.New(value.Type()).Elem()
+	switch value.Kind() {
+	case reflect.Ptr:
+		if !value.IsNil() {
+			copied.Set(reflect.New(value.Type().Elem()))
+			copied.Elem().Set(deepCopy(value.Elem()))
+		}
+	case reflect.Interface:
+		if !value.IsNil() {
+			copied.Set(deepCopy(value.Elem()))
+		}
+	case reflect.Slice:
+		if !value.IsNil() {
+			copied.Set(reflect.MakeSlice(value.Type(), value.Len(), value.Len()))
+			for i := 0; i < value.Len(); i++ {
+				copied.Index(i).Set(deepCopy(value.Index(i)))
+			}
+		}
+	case reflect.Map:
+		if !value.IsNil() {
+			copied.Set(reflect.MakeMap(value.Type()))
+			for _, key := range value.MapKeys() {
+				copied.SetMapIndex(key, deepCopy(value.MapIndex(key)))
+			}
+		}
+	case reflect.Struct:
+		copied.Set(value)
+		for i := 0; i < value.NumField(); i++ {
+			if value.Type().Field(i).PkgPath == "" {
+				copied.Field(i).Set(deepCopy(value.Field(i)))
+			}
+		}
+	default:
+		copied.Set(value)
+	}
+	return copied
+}
+
+func executeTemplate(text string, data interface{}) (string, error) {
+	tmpl, err := template.New("").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Makes processes that depend on a templated process depend on each of its
+// instances instead.
+func (c *ConfigManager) expandDependencies(instances map[string][]string) {
+	for _, pg := range c.ProcessGroups {
+		for _, process := range pg.Processes {
+			if len(process.DependsOn) == 0 {
+				continue
+			}
+			dependsOn := []string{}
+			for _, name := range process.DependsOn {
+				fullName := name
+				if !strings.Contains(name, "/") {
+					fullName = pg.Name + "/" + name
+				}
+				if names, exists := instances[fullName]; exists {
+					dependsOn = append(dependsOn, names...)
+				} else {
+					dependsOn = append(dependsOn, name)
+				}
+			}
+			process.DependsOn = dependsOn
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"fmt"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"path/filepath"
+)
+
+type InstancesSuite struct{}
+
+var _ = Suite(&InstancesSuite{})
+
+const instancesConfig = `---
+processes:
+  worker:
+    description: worker
+    instances: 3
+    pidfile: /var/run/{{.Name}}.pid
+    start: /bin/echo worker --index {{.Index}}
+    stdout: /var/log/worker-{{.Index}}.log
+    env:
+      - WORKER_INDEX={{.Index}}
+  scheduler:
+    description: scheduler
+    pidfile: /var/run/scheduler.pid
+    start: /bin/echo scheduler
+    dependson:
+      - worker
+`
+
+func (s *InstancesSuite) TestInstances(c *C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "jobs-gonit.yml")
+	if err := ioutil.WriteFile(path, []byte(instancesConfig), 0644); err != nil {
+		c.Fatal(err)
+	}
+
+	configManager := &ConfigManager{}
+	c.Assert(configManager.LoadConfig(path), IsNil)
+	pg := configManager.ProcessGroups["jobs"]
+	c.Check(len(pg.Processes), Equals, 4)
+	c.Check(pg.Processes["worker"], IsNil)
+
+	worker, err := configManager.FindProcess("jobs/worker@1")
+	c.Assert(err, IsNil)
+	c.Check(worker.Name, Equals, "worker@1")
+	c.Check(worker.Pidfile, Equals, "/var/run/worker@1.pid")
+	c.Check(worker.Start, Equals, "/bin/echo worker --index 1")
+	c.Check(worker.Stdout, Equals, "/var/log/worker-1.log")
+	c.Check(worker.Env, DeepEquals, []string{"WORKER_INDEX=1"})
+
+	scheduler, err := configManager.FindProcess("scheduler")
+	c.Assert(err, IsNil)
+	c.Check(scheduler.DependsOn, DeepEquals,
+		[]string{"jobs/worker@0", "jobs/worker@1", "jobs/worker@2"})
+}
+
+func (s *InstancesSuite) TestInstanceErrors(c *C) {
+	process := &Process{Name: "worker", Start: "/bin/worker {{.Nope}}"}
+	_, err := process.newInstance(0)
+	c.Check(err, ErrorMatches, "Process worker@0: .*Nope.*")
+
+	// an instance can't replace a process of the same name
+	configManager := &ConfigManager{ProcessGroups: map[string]*ProcessGroup{}}
+	instances := 2
+	configManager.AddProcess("jobs", &Process{Name: "worker",
+		Instances: &instances})
+	configManager.AddProcess("jobs", &Process{Name: "worker@1"})
+	c.Check(configManager.expandInstances(), ErrorMatches, "Process "+
+		"jobs/worker instance jobs/worker@1 has the same name as another "+
+		"process.")
+}
+
+func (s *InstancesSuite) TestInstancesShareNothing(c *C) {
+	process := &Process{
+		Name:    "worker",
+		Env:     []string{"INDEX={{.Index}}"},
+		Actions: map[string][]string{"alert": {"memory_high"}},
+		Hooks:   &Hooks{PreStart: []*Hook{{Command: "mkdir -p /tmp/worker"}}},
+		Ready:   []*Probe{{File: "/tmp/worker.ready"}},
+		Output:  &Output{MaxFiles: 3},
+	}
+	first, err := process.newInstance(0)
+	c.Assert(err, IsNil)
+	second, err := process.newInstance(1)
+	c.Assert(err, IsNil)
+
+	first.Actions["alert"][0] = "cpu_high"
+	first.Hooks.PreStart[0].Command = "true"
+	first.Ready[0].File = "/tmp/worker@0.ready"
+	first.Output.MaxFiles = 1
+	c.Check(second.Env, DeepEquals, []string{"INDEX=1"})
+	c.Check(second.Actions["alert"], DeepEquals, []string{"memory_high"})
+	c.Check(second.Hooks.PreStart[0].Command, Equals, "mkdir -p /tmp/worker")
+	c.Check(second.Ready[0].File, Equals, "/tmp/worker.ready")
+	c.Check(second.Output.MaxFiles, Equals, 3)
+	c.Check(process.Env, DeepEquals, []string{"INDEX={{.Index}}"})
+}
+
+const zeroInstancesConfig = `---
+processes:
+  worker:
+    description: worker
+    instances: %d
+    pidfile: %v/{{.Name}}.pid
+    start: echo $$$$ > %v/{{.Name}}.pid; exec sleep 60
+    shell: true
+`
+
+func (s *InstancesSuite) TestZeroInstances(c *C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "jobs-gonit.yml")
+	writeConfig := func(instances int) *ConfigManager {
+		config := fmt.Sprintf(zeroInstancesConfig, instances, dir, dir)
+		c.Assert(ioutil.WriteFile(path, []byte(config), 0644), IsNil)
+		configManager := &ConfigManager{}
+		c.Assert(configManager.LoadConfig(path), IsNil)
+		configManager.Settings.PersistFile = filepath.Join(dir, "state.yml")
+		return configManager
+	}
+
+	control := &Control{ConfigManager: writeConfig(2)}
+	c.Assert(control.groupAction("jobs", &ActionResult{},
+		NewGroupControlAction(ACTION_START)), IsNil)
+	workers := []*Process{}
+	for _, name := range []string{"jobs/worker@0", "jobs/worker@1"} {
+		worker, err := control.Config().FindProcess(name)
+		c.Assert(err, IsNil)
+		c.Assert(worker.IsRunning(), Equals, true)
+		workers = append(workers, worker)
+	}
+
+	// no instances, not a process named worker
+	configManager := writeConfig(0)
+	c.Check(len(configManager.ProcessGroups["jobs"].Processes), Equals, 0)
+
+	result := &ReloadResult{}
+	control.Reload(configManager, result)
+	c.Check(result.Errors, Equals, 0)
+	c.Check(result.Removed, DeepEquals,
+		[]string{"jobs/worker@0", "jobs/worker@1"})
+	c.Check(len(result.Added), Equals, 0)
+	for _, worker := range workers {
+		c.Check(worker.IsRunning(), Equals, false)
+	}
+}
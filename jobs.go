@@ -0,0 +1,302 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every action called through the API runs as a job.  The action methods
+// such as StartProcess wait for their job to finish, while SubmitJob returns
+// as soon as the job is started, with its id.  The job can then be polled
+// with Job, waited for with WaitJob and cancelled with CancelJob, and Jobs
+// lists the jobs still running.  A job records each step taken on each
+// process as it is taken.
+//
+// A cancelled job doesn't start or stop any more processes, but processes it
+// is starting or stopping at the time are waited for as usual.
+
+const (
+	JOB_RUNNING   = "running"
+	JOB_DONE      = "done"
+	JOB_FAILED    = "failed"
+	JOB_CANCELLED = "cancelled"
+)
+
+// How many finished jobs are kept for polling.
+const MAX_FINISHED_JOBS = 100
+
+type Job struct {
+	Id int
+	// The API method of the action, e.g. "StartGroup", and its argument.
+	Method   string
+	Name     string
+	State    string
+	Started  time.Time
+	Finished time.Time
+	// Every step taken so far, in the order they were taken.
+	Steps []ActionStep
+	// The result of the action, once the job has finished.
+	Result ActionResult
+	Error  string
+}
+
+// Asks for an action to be run as a job, e.g. {"StopGroup", "vcap"}.
+type JobRequest struct {
+	Method string
+	Name   string
+}
+
+// Asks to wait for a job to finish.  Timeout is a duration such as "30s",
+// no timeout if it is empty.
+type JobWait struct {
+	Id      int
+	Timeout string
+}
+
+type JobList struct {
+	Jobs []Job
+}
+
+// A job as run by Control.
+type controlJob struct {
+	sync.Mutex
+	job    Job
+	err    error
+	action *ControlAction
+	done   chan bool
+}
+
+// The jobs of Control, by id.
+type jobTable struct {
+	sync.Mutex
+	lastId   int
+	jobs     map[int]*controlJob
+	finished []int
+}
+
+// How an API method runs its action.
+type actionRunner func(name string, r *ActionResult,
+	action *ControlAction) error
+
+// Returns the runner of the action of an API method, and the action for it
+// to run.
+func (c *Control) jobAction(method string) (actionRunner, *ControlAction,
+	error) {
+	methods := []struct {
+		prefix string
+		method int
+	}{
+		{"Start", ACTION_START},
+		{"Stop", ACTION_STOP},
+		{"Restart", ACTION_RESTART},
+		{"Monitor", ACTION_MONITOR},
+		{"Unmonitor", ACTION_UNMONITOR},
+	}
+	for _, m := range methods {
+		if !strings.HasPrefix(method, m.prefix) {
+			continue
+		}
+		switch method[len(m.prefix):] {
+		case "Process":
+			return c.callAction, NewControlAction(m.method), nil
+		case "Group":
+			return c.groupAction, NewGroupControlAction(m.method), nil
+		case "All":
+			return c.allAction, NewGroupControlAction(m.method), nil
+		}
+	}
+	return nil, nil, fmt.Errorf("unknown action %q", method)
+}
+
+// Starts a job running the action of an API method on name.
+func (c *Control) submitJob(method string, name string) (*controlJob, error) {
+	run, action, err := c.jobAction(method)
+	if err != nil {
+		return nil, err
+	}
+
+	c.jobs.Lock()
+	if c.jobs.jobs == nil {
+		c.jobs.jobs = map[int]*controlJob{}
+	}
+	c.jobs.lastId++
+	job := &controlJob{
+		job: Job{
+			Id:      c.jobs.lastId,
+			Method:  method,
+			Name:    name,
+			State:   JOB_RUNNING,
+			Started: time.Now(),
+		},
+		action: action,
+		done:   make(chan bool),
+	}
+	c.jobs.jobs[job.job.Id] = job
+	c.jobs.Unlock()
+
+	action.visits.jobId = job.job.Id
+	action.visits.onStep = job.addStep
+	action.visits.byHand = true
+	Log.Infof("Job %d started: %v %v", job.job.Id, method, name)
+
+	// a reload submitted after the job waits for it
+	c.configLock.RLock()
+	go func() {
+		result := &ActionResult{}
+		err := run(name, result, action)
+		c.configLock.RUnlock()
+		c.finishJob(job, result, err)
+	}()
+	return job, nil
+}
+
+func (j *controlJob) addStep(step ActionStep) {
+	j.Lock()
+	defer j.Unlock()
+	j.job.Steps = append(j.job.Steps, step)
+}
+
+func (c *Control) finishJob(job *controlJob, result *ActionResult,
+	err error) {
+	job.Lock()
+	job.job.Result = *result
+	job.job.Finished = time.Now()
+	job.err = err
+	switch {
+	case job.action.isCancelled():
+		job.job.State = JOB_CANCELLED
+	case err != nil || result.Errors != 0:
+		job.job.State = JOB_FAILED
+	default:
+		job.job.State = JOB_DONE
+	}
+	if err != nil {
+		job.job.Error = err.Error()
+	}
+	Log.Infof("Job %d %v", job.job.Id, job.job.State)
+	job.Unlock()
+	close(job.done)
+
+	// forget the oldest finished jobs
+	c.jobs.Lock()
+	defer c.jobs.Unlock()
+	c.jobs.finished = append(c.jobs.finished, job.job.Id)
+	for len(c.jobs.finished) > MAX_FINISHED_JOBS {
+		delete(c.jobs.jobs, c.jobs.finished[0])
+		c.jobs.finished = c.jobs.finished[1:]
+	}
+}
+
+func (c *Control) findJob(id int) (*controlJob, error) {
+	c.jobs.Lock()
+	defer c.jobs.Unlock()
+	if job, exists := c.jobs.jobs[id]; exists {
+		return job, nil
+	}
+	return nil, fmt.Errorf("job %d not found", id)
+}
+
+// Returns a copy of the job as it is now.
+func (j *controlJob) snapshot() Job {
+	j.Lock()
+	defer j.Unlock()
+	job := j.job
+	job.Steps = append([]ActionStep{}, j.job.Steps...)
+	return job
+}
+
+// Runs the action of an API method as a job and waits for it to finish.
+func (c *Control) runJob(method string, name string, r *ActionResult) error {
+	job, err := c.submitJob(method, name)
+	if err != nil {
+		return err
+	}
+	<-job.done
+	job.Lock()
+	defer job.Unlock()
+	*r = job.job.Result
+	return job.err
+}
+
+// Starts an action as a job, without waiting for it to finish.
+func (a *API) SubmitJob(request JobRequest, r *Job) error {
+	job, err := a.Control.submitJob(request.Method, request.Name)
+	if err != nil {
+		return err
+	}
+	*r = job.snapshot()
+	return nil
+}
+
+// Returns the job with the given id.
+func (a *API) Job(id int, r *Job) error {
+	job, err := a.Control.findJob(id)
+	if err != nil {
+		return err
+	}
+	*r = job.snapshot()
+	return nil
+}
+
+// Waits until a job has finished or the timeout has passed, and returns it
+// either way.
+func (a *API) WaitJob(wait JobWait, r *Job) error {
+	job, err := a.Control.findJob(wait.Id)
+	if err != nil {
+		return err
+	}
+	if wait.Timeout == "" {
+		<-job.done
+	} else {
+		timeout, err := time.ParseDuration(wait.Timeout)
+		if err != nil {
+			return fmt.Errorf("timeout '%v' is not a duration", wait.Timeout)
+		}
+		select {
+		case <-job.done:
+		case <-time.After(timeout):
+		}
+	}
+	*r = job.snapshot()
+	return nil
+}
+
+// Cancels a running job, and returns it.
+func (a *API) CancelJob(id int, r *Job) error {
+	job, err := a.Control.findJob(id)
+	if err != nil {
+		return err
+	}
+	job.action.cancel()
+	Log.Infof("Job %d cancelled", id)
+	*r = job.snapshot()
+	return nil
+}
+
+// Lists the running jobs.
+func (a *API) Jobs(unused interface{}, r *JobList) error {
+	c := a.Control
+	c.jobs.Lock()
+	ids := []int{}
+	for id, job := range c.jobs.jobs {
+		select {
+		case <-job.done:
+		default:
+			ids = append(ids, id)
+		}
+	}
+	c.jobs.Unlock()
+
+	sort.Ints(ids)
+	for _, id := range ids {
+		if job, err := c.findJob(id); err == nil {
+			r.Jobs = append(r.Jobs, job.snapshot())
+		}
+	}
+	return nil
+}
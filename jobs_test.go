@@ -0,0 +1,154 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit_test
+
+import (
+	. "github.com/cloudfoundry/gonit"
+	. "launchpad.net/gocheck"
+	"path/filepath"
+	"strings"
+)
+
+type JobsSuite struct {
+	dir       string
+	api       *API
+	processes []*Process
+}
+
+var _ = Suite(&JobsSuite{})
+
+const jobsGroup = "jobs"
+
+func (s *JobsSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+
+	configManager := &ConfigManager{
+		Settings: &Settings{PersistFile: filepath.Join(s.dir, "state.yml")},
+	}
+	s.api = NewAPI(configManager)
+	s.api.Control.EventMonitor = &FakeEventMonitor{}
+	s.processes = nil
+	for _, name := range []string{"a", "b", "c"} {
+		pidfile := filepath.Join(s.dir, name+".pid")
+		process := &Process{
+			Name:    name,
+			Pidfile: pidfile,
+			Start:   "sleep 0.3; echo $$ > " + pidfile + "; exec sleep 60",
+			Shell:   true,
+		}
+		c.Assert(s.api.Control.Config().AddProcess(jobsGroup, process), IsNil)
+		s.processes = append(s.processes, process)
+	}
+}
+
+func (s *JobsSuite) TearDownTest(c *C) {
+	s.api.StopGroup(jobsGroup, &ActionResult{})
+}
+
+func (s *JobsSuite) TestSubmitAndWait(c *C) {
+	job := &Job{}
+	c.Assert(s.api.SubmitJob(JobRequest{"StartGroup", jobsGroup}, job), IsNil)
+	c.Check(job.State, Equals, JOB_RUNNING)
+	id := job.Id
+
+	list := &JobList{}
+	c.Assert(s.api.Jobs(nil, list), IsNil)
+	c.Assert(len(list.Jobs), Equals, 1)
+	c.Check(list.Jobs[0].Id, Equals, id)
+
+	// gives up waiting, the job is still running
+	job = &Job{}
+	c.Assert(s.api.WaitJob(JobWait{Id: id, Timeout: "10ms"}, job), IsNil)
+	c.Check(job.State, Equals, JOB_RUNNING)
+
+	job = &Job{}
+	c.Assert(s.api.WaitJob(JobWait{Id: id}, job), IsNil)
+	c.Check(job.State, Equals, JOB_DONE)
+	c.Check(job.Result.Total, Equals, 3)
+	c.Check(job.Result.Errors, Equals, 0)
+	for _, process := range s.processes {
+		c.Check(process.IsRunning(), Equals, true)
+	}
+	c.Check(len(job.Steps), Equals, 6)
+	c.Check(len(job.Result.Steps), Equals, 6)
+	checkSteps(c, job.Steps, "starting", "started")
+
+	// finished jobs can still be polled, but aren't listed
+	c.Assert(s.api.Job(id, &Job{}), IsNil)
+	list = &JobList{}
+	c.Assert(s.api.Jobs(nil, list), IsNil)
+	c.Check(len(list.Jobs), Equals, 0)
+
+	// the steps of a job are recorded as they are taken
+	job = &Job{}
+	c.Assert(s.api.SubmitJob(JobRequest{"StopGroup", jobsGroup}, job), IsNil)
+	c.Assert(s.api.WaitJob(JobWait{Id: job.Id}, job), IsNil)
+	c.Check(job.State, Equals, JOB_DONE)
+	c.Check(len(job.Steps), Equals, 3)
+	c.Check(len(job.Result.Steps), Equals, 3)
+
+	job = &Job{}
+	c.Assert(s.api.SubmitJob(JobRequest{"StartGroup", jobsGroup}, job), IsNil)
+	c.Assert(s.api.WaitJob(JobWait{Id: job.Id}, job), IsNil)
+	c.Check(job.State, Equals, JOB_DONE)
+	c.Check(len(job.Steps), Equals, 6)
+
+	job = &Job{}
+	c.Assert(s.api.SubmitJob(JobRequest{"RestartGroup", jobsGroup}, job),
+		IsNil)
+	c.Assert(s.api.WaitJob(JobWait{Id: job.Id}, job), IsNil)
+	c.Check(job.State, Equals, JOB_DONE)
+	c.Check(job.Result.Errors, Equals, 0)
+	c.Check(len(job.Steps), Equals, 9)
+	c.Check(len(job.Result.Steps), Equals, 9)
+	checkSteps(c, job.Steps, "sending SIGTERM", "starting", "started")
+}
+
+// Checks that each process took the given steps, in order.
+func checkSteps(c *C, steps []ActionStep, messages ...string) {
+	taken := make(map[string][]string)
+	for _, step := range steps {
+		taken[step.Process] = append(taken[step.Process], step.Message)
+	}
+	c.Check(len(taken), Equals, 3)
+	for process, processSteps := range taken {
+		c.Check(processSteps, DeepEquals, messages, Commentf("%v", process))
+	}
+}
+
+func (s *JobsSuite) TestCancel(c *C) {
+	s.api.Control.Config().Settings.ActionConcurrency = 1
+
+	job := &Job{}
+	c.Assert(s.api.SubmitJob(JobRequest{"StartGroup", jobsGroup}, job), IsNil)
+	// while the first process is starting
+	c.Assert(s.api.WaitJob(JobWait{Id: job.Id, Timeout: "100ms"}, job), IsNil)
+	c.Assert(s.api.CancelJob(job.Id, job), IsNil)
+	c.Assert(s.api.WaitJob(JobWait{Id: job.Id}, job), IsNil)
+	c.Check(job.State, Equals, JOB_CANCELLED)
+
+	// the process being started when the job was cancelled still started
+	running := 0
+	for _, process := range s.processes {
+		if process.IsRunning() {
+			running++
+		}
+	}
+	c.Check(running, Equals, 1)
+	c.Check(len(job.Steps), Equals, 4)
+	cancelled := 0
+	for _, step := range job.Steps {
+		if strings.HasSuffix(step.Message, "the action was cancelled") {
+			cancelled++
+		}
+	}
+	c.Check(cancelled, Equals, 2)
+}
+
+func (s *JobsSuite) TestErrors(c *C) {
+	err := s.api.SubmitJob(JobRequest{"RebootAll", ""}, &Job{})
+	c.Check(err, ErrorMatches, `unknown action "RebootAll"`)
+	c.Check(s.api.Job(12345, &Job{}), ErrorMatches, "job 12345 not found")
+	err = s.api.WaitJob(JobWait{Id: 12345}, &Job{})
+	c.Check(err, ErrorMatches, "job 12345 not found")
+}
@@ -0,0 +1,304 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// By default the stdout and stderr files of a process are opened for append
+// and handed to the child, so they can't be rotated without restarting it.
+// With 'output' set, gonit gives the child a pipe instead and writes what it
+// reads to the files itself, rotating them by size and reopening them when
+// gonit gets a SIGHUP, e.g. after logrotate moved them:
+//
+//   web:
+//     stdout: /var/vcap/sys/log/web.log
+//     output:
+//       max_size: 10mb
+//       max_files: 5
+//       timestamps: true
+//
+// A file is closed once nothing writes to it any more, e.g. when its process
+// stopped or was removed from the config.
+//
+// The pipe outlives the process that was started, so output of programs that
+// daemonize is captured as well as that of supervised ones.  It doesn't
+// outlive gonit though: once gonit exits or is restarted, writes to the pipe
+// fail with EPIPE, and processes that don't ignore SIGPIPE are killed by it.
+// Supervised processes go down with gonit anyway, but daemons that should
+// keep running without it are better left writing to their files directly.
+//
+// Lines longer than MAX_OUTPUT_LINE are split, so a child writing without
+// newlines can't make gonit buffer its output without bound.
+
+const (
+	DEFAULT_OUTPUT_MAX_FILES = 5
+	OUTPUT_TIMESTAMP_FORMAT  = "2006-01-02T15:04:05.000Z07:00"
+	MAX_OUTPUT_LINE          = 64 * 1024
+)
+
+// How gonit manages the output files of a process.
+type Output struct {
+	MaxSize    string `yaml:"max_size"`
+	MaxFiles   int    `yaml:"max_files"`
+	Timestamps bool
+}
+
+// A file that process output is written to.  Once it reaches maxSize it is
+// renamed to path.1, path.1 to path.2 and so on, keeping maxFiles old files.
+type OutputWriter struct {
+	sync.Mutex
+	path       string
+	maxSize    uint64
+	maxFiles   int
+	timestamps bool
+	file       *os.File
+	size       uint64
+	// how many copies write to it, guarded by outputWriters
+	users int
+}
+
+// The open output files, by path, so that processes writing to the same
+// file share a writer.
+var outputWriters = struct {
+	sync.Mutex
+	writers map[string]*OutputWriter
+}{writers: map[string]*OutputWriter{}}
+
+// Checks the output settings of a process.
+func (p *Process) validateOutput() error {
+	if p.Output == nil {
+		return nil
+	}
+	errs := ConfigErrors{}
+	if p.Stdout == "" && p.Stderr == "" {
+		errs.add(fmt.Errorf("Process %v has output settings but no stdout or "+
+			"stderr.", p.FullName()))
+	}
+	if _, err := p.Output.maxSize(); err != nil {
+		errs.add(fmt.Errorf("Process %v output max_size: %v.", p.FullName(),
+			err))
+	}
+	if p.Output.MaxFiles < 0 {
+		errs.add(fmt.Errorf("Process %v output max_files must not be negative.",
+			p.FullName()))
+	}
+	return errs.errOrNil()
+}
+
+// Returns the size files are rotated at, 0 for never.
+func (o *Output) maxSize() (uint64, error) {
+	if o.MaxSize == "" {
+		return 0, nil
+	}
+	return parseLimitAmount(o.MaxSize, true)
+}
+
+// Returns the writer for the output file at path, opening it if needed.
+// Settings of a writer that is already open are updated.  The writer is to
+// be released once it is no longer written to.
+func openOutputWriter(path string, output *Output) (*OutputWriter, error) {
+	maxSize, err := output.maxSize()
+	if err != nil {
+		return nil, err
+	}
+	maxFiles := output.MaxFiles
+	if maxFiles == 0 {
+		maxFiles = DEFAULT_OUTPUT_MAX_FILES
+	}
+
+	outputWriters.Lock()
+	defer outputWriters.Unlock()
+	w, exists := outputWriters.writers[path]
+	if !exists {
+		w = &OutputWriter{path: path}
+		if err := w.open(); err != nil {
+			return nil, err
+		}
+		outputWriters.writers[path] = w
+	}
+	w.users++
+	w.Lock()
+	w.maxSize, w.maxFiles, w.timestamps = maxSize, maxFiles, output.Timestamps
+	w.Unlock()
+	return w, nil
+}
+
+// Reopens every output file, so files moved away by another program are
+// created again.
+func ReopenOutputs() {
+	outputWriters.Lock()
+	defer outputWriters.Unlock()
+	paths := []string{}
+	for path := range outputWriters.writers {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := outputWriters.writers[path].Reopen(); err != nil {
+			Log.Errorf("Error reopening output file %v: %v", path, err)
+		}
+	}
+}
+
+func (w *OutputWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file, w.size = file, uint64(info.Size())
+	return nil
+}
+
+// Lets go of a writer returned by openOutputWriter.  Its file is closed once
+// no one else writes to it either.
+func (w *OutputWriter) release() {
+	outputWriters.Lock()
+	defer outputWriters.Unlock()
+	w.users--
+	if w.users > 0 {
+		return
+	}
+	if outputWriters.writers[w.path] == w {
+		delete(outputWriters.writers, w.path)
+	}
+	w.Lock()
+	defer w.Unlock()
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+}
+
+// Closes and opens the file again.
+func (w *OutputWriter) Reopen() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	return w.open()
+}
+
+// Writes one line of output, rotating the file first if the line would take
+// it over its maximum size.
+func (w *OutputWriter) WriteLine(line []byte) error {
+	w.Lock()
+	defer w.Unlock()
+	if w.timestamps {
+		stamp := time.Now().Format(OUTPUT_TIMESTAMP_FORMAT) + " "
+		line = append([]byte(stamp), line...)
+	}
+	if w.maxSize != 0 && w.size != 0 &&
+		w.size+uint64(len(line)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	n, err := w.file.Write(line)
+	w.size += uint64(n)
+	return err
+}
+
+func (w *OutputWriter) rotate() error {
+	w.file.Close()
+	w.file = nil
+	os.Remove(w.rotatedPath(w.maxFiles))
+	for i := w.maxFiles - 1; i > 0; i-- {
+		os.Rename(w.rotatedPath(i), w.rotatedPath(i+1))
+	}
+	if err := os.Rename(w.path, w.rotatedPath(1)); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+func (w *OutputWriter) rotatedPath(n int) string {
+	return w.path + "." + strconv.Itoa(n)
+}
+
+// Copies output from the child to w a line at a time, until the last
+// process holding the pipe closes it.  Releases w when done.
+func copyOutput(r io.ReadCloser, w *OutputWriter) {
+	defer w.release()
+	defer r.Close()
+	reader := bufio.NewReaderSize(r, MAX_OUTPUT_LINE)
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			line = append(append([]byte{}, line...), '\n')
+			err = nil
+		}
+		if len(line) != 0 {
+			if err := w.WriteLine(line); err != nil {
+				Log.Errorf("Error writing output to %v: %v", w.path, err)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Gives the child a pipe for each managed output file.  Returns the ends of
+// the pipes to close once the child has started.
+func (p *Process) redirectOutput(stdout *io.Writer,
+	stderr *io.Writer) ([]*os.File, error) {
+	pipes := map[string]*os.File{}
+	childEnds := []*os.File{}
+	for _, redirect := range []struct {
+		path string
+		fd   *io.Writer
+	}{{p.Stdout, stdout}, {p.Stderr, stderr}} {
+		if redirect.path == "" {
+			continue
+		}
+		// stdout and stderr going to the same file share a pipe, which keeps
+		// their lines in order
+		if pipe, exists := pipes[redirect.path]; exists {
+			*redirect.fd = pipe
+			continue
+		}
+		w, err := openOutputWriter(redirect.path, p.Output)
+		if err != nil {
+			closeFiles(childEnds)
+			return nil, err
+		}
+		r, pipe, err := os.Pipe()
+		if err != nil {
+			w.release()
+			closeFiles(childEnds)
+			return nil, err
+		}
+		go copyOutput(r, w)
+		pipes[redirect.path] = pipe
+		childEnds = append(childEnds, pipe)
+		*redirect.fd = pipe
+	}
+	return childEnds, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type OutputSuite struct {
+	dir string
+}
+
+var _ = Suite(&OutputSuite{})
+
+func (s *OutputSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *OutputSuite) readFile(c *C, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	c.Check(err, IsNil)
+	return string(data)
+}
+
+func (s *OutputSuite) TestRotate(c *C) {
+	path := filepath.Join(s.dir, "web.log")
+	w, err := openOutputWriter(path, &Output{MaxSize: "10", MaxFiles: 2})
+	c.Assert(err, IsNil)
+	defer w.release()
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n",
+		"five\n", "six\n"} {
+		c.Check(w.WriteLine([]byte(line)), IsNil)
+	}
+	c.Check(s.readFile(c, "web.log"), Equals, "six\n")
+	c.Check(s.readFile(c, "web.log.1"), Equals, "four\nfive\n")
+	c.Check(s.readFile(c, "web.log.2"), Equals, "three\n")
+	_, err = os.Stat(path + ".3")
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *OutputSuite) TestReopen(c *C) {
+	path := filepath.Join(s.dir, "web.log")
+	w, err := openOutputWriter(path, &Output{Timestamps: true})
+	c.Assert(err, IsNil)
+	defer w.release()
+	c.Check(w.WriteLine([]byte("before\n")), IsNil)
+
+	c.Assert(os.Rename(path, path+".old"), IsNil)
+	ReopenOutputs()
+	c.Check(w.WriteLine([]byte("after\n")), IsNil)
+
+	stamp := `\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}\S+ `
+	c.Check(s.readFile(c, "web.log.old"), Matches, stamp+"before\n")
+	c.Check(s.readFile(c, "web.log"), Matches, stamp+"after\n")
+}
+
+func (s *OutputSuite) TestManagedOutput(c *C) {
+	exits := make(chan string, 1)
+	process := &Process{
+		Name:      "echo",
+		Start:     "echo out; echo err >&2",
+		Shell:     true,
+		Supervise: true,
+		Stdout:    filepath.Join(s.dir, "echo.log"),
+		Stderr:    filepath.Join(s.dir, "echo.log"),
+		Output:    &Output{MaxSize: "1mb"},
+	}
+	_, err := process.startProcess(exits)
+	c.Assert(err, IsNil)
+	<-exits
+
+	// the copy finishes after the child exits
+	output := ""
+	for i := 0; i < 50 && output != "out\nerr\n"; i++ {
+		time.Sleep(10 * time.Millisecond)
+		output = s.readFile(c, "echo.log")
+	}
+	c.Check(output, Equals, "out\nerr\n")
+}
+
+func (s *OutputSuite) TestLongLines(c *C) {
+	path := filepath.Join(s.dir, "long.log")
+	w, err := openOutputWriter(path, &Output{})
+	c.Assert(err, IsNil)
+	long := strings.Repeat("x", MAX_OUTPUT_LINE+10)
+	copyOutput(ioutil.NopCloser(strings.NewReader(long+"\nshort\n")), w)
+
+	lines := strings.Split(s.readFile(c, "long.log"), "\n")
+	c.Assert(len(lines), Equals, 4)
+	c.Check(len(lines[0]), Equals, MAX_OUTPUT_LINE)
+	c.Check(lines[1], Equals, "xxxxxxxxxx")
+	c.Check(lines[2], Equals, "short")
+}
+
+func (s *OutputSuite) TestRelease(c *C) {
+	path := filepath.Join(s.dir, "web.log")
+	w, err := openOutputWriter(path, &Output{})
+	c.Assert(err, IsNil)
+	shared, err := openOutputWriter(path, &Output{})
+	c.Assert(err, IsNil)
+	c.Check(shared, Equals, w)
+
+	// closed once the last copy is done with it
+	copyOutput(ioutil.NopCloser(strings.NewReader("one\n")), w)
+	c.Check(w.file, NotNil)
+	copyOutput(ioutil.NopCloser(strings.NewReader("two\n")), shared)
+	c.Check(w.file, IsNil)
+	outputWriters.Lock()
+	_, open := outputWriters.writers[path]
+	outputWriters.Unlock()
+	c.Check(open, Equals, false)
+	c.Check(s.readFile(c, "web.log"), Equals, "one\ntwo\n")
+}
+
+func (s *OutputSuite) TestValidateOutput(c *C) {
+	process := &Process{Name: "web",
+		Output: &Output{MaxSize: "big", MaxFiles: -1}}
+	err := process.validateOutput()
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Process web has output settings but no "+
+		"stdout or stderr.\nProcess web output max_size: 'big' is not a "+
+		"limit.\nProcess web output max_files must not be negative.")
+}
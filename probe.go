@@ -0,0 +1,225 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// A process with readiness probes only counts as started once it is running
+// and all of its probes pass, so processes that depend on it aren't started
+// before it is serving.  Each probe checks one thing:
+//
+//   web:
+//     ready:
+//       - tcp: 127.0.0.1:8080
+//       - http: http://127.0.0.1:8080/healthz
+//         status: 200
+//         timeout: 2s
+//       - unix: /var/vcap/sys/run/web.sock
+//       - file: /var/vcap/sys/run/web.ready
+//       - command: /var/vcap/jobs/web/bin/ready
+//
+// An http probe passes on any 2xx or 3xx status unless a status is given,
+// and a command probe passes when the command exits 0.  Probes are retried
+// until the start timeout runs out, command probes only once per interval,
+// 1s unless set.  A command probe runs like a hook, with its output thrown
+// away.
+
+const (
+	DEFAULT_PROBE_TIMEOUT  = "1s"
+	DEFAULT_PROBE_INTERVAL = "1s"
+)
+
+// A readiness check of a process.
+type Probe struct {
+	Tcp      string
+	Unix     string
+	Http     string
+	File     string
+	Command  string
+	Status   int
+	Timeout  string
+	Interval string
+}
+
+// Returns the kind of probe and what it checks.
+func (p *Probe) kind() (string, string) {
+	kinds := []struct{ name, target string }{
+		{"tcp", p.Tcp}, {"unix", p.Unix}, {"http", p.Http}, {"file", p.File},
+		{"command", p.Command},
+	}
+	for _, kind := range kinds {
+		if kind.target != "" {
+			return kind.name, kind.target
+		}
+	}
+	return "", ""
+}
+
+func (p *Probe) String() string {
+	kind, target := p.kind()
+	return kind + " " + target
+}
+
+func (p *Probe) timeout() time.Duration {
+	return parseTimeout(p.Timeout, DEFAULT_PROBE_TIMEOUT)
+}
+
+func (p *Probe) interval() time.Duration {
+	return parseTimeout(p.Interval, DEFAULT_PROBE_INTERVAL)
+}
+
+// Checks the readiness probes of a process.  Probe commands are checked
+// along with the other programs of the process.
+func (p *Process) validateProbes() error {
+	errs := ConfigErrors{}
+	for i, probe := range p.Ready {
+		what := fmt.Sprintf("Process %v ready probe %v", p.FullName(), i+1)
+		if probe == nil {
+			errs.add(fmt.Errorf("%v is empty.", what))
+			continue
+		}
+		set := 0
+		for _, target := range []string{probe.Tcp, probe.Unix, probe.Http,
+			probe.File, probe.Command} {
+			if target != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			errs.add(fmt.Errorf("%v must have exactly one of tcp, unix, http, "+
+				"file and command.", what))
+			continue
+		}
+		if probe.Http != "" && !strings.HasPrefix(probe.Http, "http://") &&
+			!strings.HasPrefix(probe.Http, "https://") {
+			errs.add(fmt.Errorf("%v http '%v' is not an http url.", what,
+				probe.Http))
+		}
+		if probe.Status != 0 && probe.Http == "" {
+			errs.add(fmt.Errorf("%v status only applies to http probes.", what))
+		}
+		if probe.Interval != "" && probe.Command == "" {
+			errs.add(fmt.Errorf("%v interval only applies to command probes.",
+				what))
+		}
+		errs.add(validateTimeout(what+" timeout", probe.Timeout))
+		errs.add(validateTimeout(what+" interval", probe.Interval))
+	}
+	return errs.errOrNil()
+}
+
+// Runs a probe against the process once.
+func (p *Probe) check(process *Process) error {
+	timeout := p.timeout()
+	switch {
+	case p.Tcp != "":
+		return dialProbe("tcp", p.Tcp, timeout)
+	case p.Unix != "":
+		return dialProbe("unix", p.Unix, timeout)
+	case p.Http != "":
+		return p.checkHttp(timeout)
+	case p.File != "":
+		_, err := os.Stat(p.File)
+		return err
+	case p.Command != "":
+		return p.checkCommand(process)
+	}
+	return fmt.Errorf("empty probe")
+}
+
+// Runs a command probe as a hook of the process, without its output files.
+func (p *Probe) checkCommand(process *Process) error {
+	hook := &Hook{Command: p.Command, Timeout: p.Timeout}
+	if p.Timeout == "" {
+		hook.Timeout = DEFAULT_PROBE_TIMEOUT
+	}
+	quiet := *process
+	quiet.Stdout, quiet.Stderr = "", ""
+	return hook.run(&quiet)
+}
+
+func dialProbe(network string, address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (p *Probe) checkHttp(timeout time.Duration) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, address string) (net.Conn, error) {
+				conn, err := net.DialTimeout(network, address, timeout)
+				if err != nil {
+					return nil, err
+				}
+				conn.SetDeadline(time.Now().Add(timeout))
+				return conn, nil
+			},
+			DisableKeepAlives: true,
+		},
+	}
+	response, err := client.Get(p.Http)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if p.Status != 0 {
+		if response.StatusCode != p.Status {
+			return fmt.Errorf("status %v, not %v", response.StatusCode, p.Status)
+		}
+	} else if response.StatusCode < 200 || response.StatusCode >= 400 {
+		return fmt.Errorf("status %v", response.StatusCode)
+	}
+	return nil
+}
+
+// Checks the readiness probes of a process over and over while waiting for
+// it to start, running each command probe at most once per interval.
+type readiness struct {
+	process *Process
+	ran     map[*Probe]time.Time
+	passed  map[*Probe]bool
+}
+
+func newReadiness(process *Process) *readiness {
+	return &readiness{
+		process: process,
+		ran:     map[*Probe]time.Time{},
+		passed:  map[*Probe]bool{},
+	}
+}
+
+// Returns whether all readiness probes of the process pass.
+func (r *readiness) ready() bool {
+	for _, probe := range r.process.Ready {
+		if ran, exists := r.ran[probe]; exists && probe.Command != "" &&
+			time.Since(ran) < probe.interval() {
+			if !r.passed[probe] {
+				return false
+			}
+			continue
+		}
+		err := probe.check(r.process)
+		r.ran[probe], r.passed[probe] = time.Now(), err == nil
+		if err != nil {
+			Log.Debugf("process %q is not ready, probe %v: %v",
+				r.process.FullName(), probe, err)
+			return false
+		}
+	}
+	return true
+}
+
+// Returns whether all readiness probes of the process pass now.
+func (p *Process) isReady() bool {
+	return newReadiness(p).ready()
+}
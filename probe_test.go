@@ -0,0 +1,102 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+)
+
+type ProbeSuite struct{}
+
+var _ = Suite(&ProbeSuite{})
+
+func (s *ProbeSuite) TestNetworkProbes(c *C) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	address := tcp.Addr().String()
+	c.Check((&Probe{Tcp: address}).check(nil), IsNil)
+	tcp.Close()
+	c.Check((&Probe{Tcp: address}).check(nil), NotNil)
+
+	socket := filepath.Join(c.MkDir(), "web.sock")
+	unix, err := net.Listen("unix", socket)
+	c.Assert(err, IsNil)
+	defer unix.Close()
+	c.Check((&Probe{Unix: socket}).check(nil), IsNil)
+
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	probe := &Probe{Http: server.URL + "/healthz"}
+	c.Check(probe.check(nil), ErrorMatches, "status 503")
+	status = http.StatusOK
+	c.Check(probe.check(nil), IsNil)
+	probe.Status = http.StatusNoContent
+	c.Check(probe.check(nil), ErrorMatches, "status 200, not 204")
+}
+
+func (s *ProbeSuite) TestFileAndCommandProbes(c *C) {
+	dir := c.MkDir()
+	ready := filepath.Join(dir, "ready")
+	process := &Process{
+		Name: "web",
+		Ready: []*Probe{{File: ready},
+			{Command: "test -f " + ready, Timeout: "5s"}},
+	}
+	c.Check(process.isReady(), Equals, false)
+	c.Assert(ioutil.WriteFile(ready, nil, 0644), IsNil)
+	c.Check(process.isReady(), Equals, true)
+}
+
+func (s *ProbeSuite) TestCommandProbeInterval(c *C) {
+	dir := c.MkDir()
+	runs := filepath.Join(dir, "runs")
+	process := &Process{
+		Name:   "web",
+		Stdout: filepath.Join(dir, "web.log"),
+		Output: &Output{},
+		Ready: []*Probe{{Command: "sh -c 'echo run >> " + runs +
+			"; echo noise; exit 1'", Interval: "1h"}},
+	}
+	readiness := newReadiness(process)
+	c.Check(readiness.ready(), Equals, false)
+	c.Check(readiness.ready(), Equals, false)
+
+	data, err := ioutil.ReadFile(runs)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "run\n")
+	_, err = os.Stat(process.Stdout)
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *ProbeSuite) TestValidateProbes(c *C) {
+	process := &Process{
+		Name: "web",
+		Ready: []*Probe{
+			{Tcp: ":8080", File: "/tmp/ready"},
+			{Http: "localhost:8080"},
+			{File: "/tmp/ready", Status: 200, Timeout: "forever",
+				Interval: "1s"},
+			{Command: "bin/ready"},
+		},
+	}
+	err := process.validateProbes()
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Process web ready probe 1 must have "+
+		"exactly one of tcp, unix, http, file and command.\n"+
+		"Process web ready probe 2 http 'localhost:8080' is not an http url.\n"+
+		"Process web ready probe 3 status only applies to http probes.\n"+
+		"Process web ready probe 3 interval only applies to command probes.\n"+
+		"Process web ready probe 3 timeout 'forever' is not a duration.")
+	c.Check(process.commands(), DeepEquals,
+		[][2]string{{"ready probe 4", "bin/ready"}})
+}
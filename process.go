@@ -3,18 +3,18 @@
 package gonit
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/binary"
-	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/user"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 var byteOrder = binary.LittleEndian
@@ -41,29 +41,30 @@ func (p *Process) Redirect(fd *io.Writer, where string) error {
 // exec.Cmd wrapper
 func (p *Process) Command(program string) (*exec.Cmd, error) {
 	var credential *syscall.Credential
+	var account *user.User
 
 	if p.Uid != "" || p.Gid != "" {
 		credential = &syscall.Credential{}
-		err := p.lookupCredentials(credential)
-		if err != nil {
+		var err error
+		if account, err = p.lookupCredentials(credential); err != nil {
 			return nil, err
 		}
 	}
 
-	argv := strings.Fields(program)
+	argv, err := p.commandArgs(program)
+	if err != nil {
+		return nil, err
+	}
 
-	path, err := exec.LookPath(argv[0])
+	env, err := p.environment(account)
 	if err != nil {
 		return nil, err
 	}
 
-	// XXX moar defaults
-	defaultEnv := []string{
-		DEFAULT_ENV_PATH,
+	path, err := p.lookPath(argv[0], env)
+	if err != nil {
+		return nil, err
 	}
-	env := make([]string, len(defaultEnv)+len(p.Env))
-	copy(env, defaultEnv)
-	copy(env[len(defaultEnv):], p.Env)
 
 	cmd := &exec.Cmd{
 		Path: path,
@@ -76,67 +77,345 @@ func (p *Process) Command(program string) (*exec.Cmd, error) {
 		},
 	}
 
+	if p.usesSpawnHelper() {
+		if err := p.wrapSpawnHelper(cmd); err != nil {
+			return nil, err
+		}
+	}
+
 	return cmd, nil
 }
 
+// Finds a program like exec.LookPath, but the way the process will run it:
+// in the PATH of its environment env, with relative paths starting from its
+// dir, and inside its chroot if it has one.  The path returned is the one to
+// exec, after the chroot.
+func (p *Process) lookPath(file string, env []string) (string, error) {
+	if strings.Contains(file, "/") {
+		if err := p.checkExecutable(file); err != nil {
+			return "", fmt.Errorf("exec: %q: %v", file, err)
+		}
+		return file, nil
+	}
+	for _, dir := range filepath.SplitList(envValue(env, "PATH")) {
+		if dir == "" {
+			dir = "."
+		}
+		path := filepath.Join(dir, file)
+		if p.checkExecutable(path) == nil {
+			return path, nil
+		}
+	}
+	if p.Chroot != "" {
+		return "", fmt.Errorf("exec: %q: executable file not found in "+
+			"chroot %v", file, p.Chroot)
+	}
+	return "", fmt.Errorf("exec: %q: executable file not found in $PATH",
+		file)
+}
+
+func (p *Process) checkExecutable(path string) error {
+	info, err := os.Stat(p.hostPath(path))
+	if err != nil {
+		return err
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// Returns where a path of the process is outside its chroot, with relative
+// paths starting from its dir.
+func (p *Process) hostPath(path string) string {
+	if !filepath.IsAbs(path) && p.Dir != "" {
+		path = filepath.Join(p.Dir, path)
+	}
+	if p.Chroot != "" {
+		path = filepath.Join(p.Chroot, path)
+	}
+	return path
+}
+
 // Fork+Exec program with std{out,err} redirected and
 // new session so program becomes the session and process group leader.
 func (p *Process) Spawn(program string) (*exec.Cmd, error) {
-	cmd, err := p.Command(program)
+	cmd, pipes, err := p.redirectedCommand(program)
 
 	if err != nil {
 		return nil, err
 	}
 
+	// the child has its own copies once started
+	defer closeFiles(pipes)
+
+	err = cmd.Start()
+
+	return cmd, err
+}
+
+// Returns the command for program with std{out,err} redirected, and the
+// pipes of managed output to close once it has started.
+func (p *Process) redirectedCommand(program string) (*exec.Cmd,
+	[]*os.File, error) {
+	cmd, err := p.Command(program)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if p.Output != nil {
+		pipes, err := p.redirectOutput(&cmd.Stdout, &cmd.Stderr)
+		return cmd, pipes, err
+	}
+
 	err = p.Redirect(&cmd.Stderr, p.Stderr)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	err = p.Redirect(&cmd.Stdout, p.Stdout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cmd, nil, nil
+}
+
+// Spawn program and wait for it to exit, recording how it exited along with
+// the tail of its output.  A program still running after timeout is killed,
+// along with anything it started.
+func (p *Process) runCommand(name string, program string,
+	timeout time.Duration) error {
+	cmd, pipes, err := p.redirectedCommand(program)
+	if err != nil {
+		return err
+	}
+	defer closeFiles(pipes)
+
+	// a pipe would keep Wait waiting for any child the program left running
+	// in the background, so its output is read back from files instead
+	stdout, err := captureOutput(name, &cmd.Stdout, nil)
+	if err != nil {
+		return err
+	}
+	defer stdout.close()
+	stderr, err := captureOutput(name, &cmd.Stderr, stdout)
+	if err != nil {
+		return err
+	}
+	defer stderr.close()
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		err = fmt.Errorf("%v program timed out after %v", name, timeout)
+	}
+
+	output := &tailBuffer{max: MAX_EXIT_OUTPUT}
+	stdout.tail(output)
+	stderr.tail(output)
+	if cmd.ProcessState != nil {
+		p.recordExit(newExitStatus(name, cmd.ProcessState, output.String()))
+	}
+	return err
+}
+
+// Where a command writes one of stdout or stderr, to read its tail back from
+// once it has exited.  Output that would go to a pipe or nowhere goes to a
+// temporary file, and is copied on to where it was going afterwards.
+type capturedOutput struct {
+	file   *os.File
+	offset int64
+	temp   bool
+	copyTo io.Writer
+}
+
+// Sets up the capture of output written to *w, unless it goes where
+// other, the other output of the command, already does.
+func captureOutput(name string, w *io.Writer,
+	other *capturedOutput) (*capturedOutput, error) {
+	if other != nil && (*w == other.copyTo || *w == io.Writer(other.file)) {
+		*w = other.file
+		return &capturedOutput{}, nil
+	}
+	if file, ok := (*w).(*os.File); ok {
+		if info, err := file.Stat(); err == nil && info.Mode().IsRegular() {
+			if other != nil && !other.temp && other.file != nil &&
+				other.file.Name() == file.Name() {
+				return &capturedOutput{}, nil
+			}
+			offset, err := file.Seek(0, os.SEEK_END)
+			if err != nil {
+				return nil, err
+			}
+			return &capturedOutput{file: file, offset: offset}, nil
+		}
+	}
+	file, err := ioutil.TempFile("", "gonit-"+name)
 	if err != nil {
 		return nil, err
 	}
+	os.Remove(file.Name())
+	captured := &capturedOutput{file: file, temp: true, copyTo: *w}
+	*w = file
+	return captured, nil
+}
 
-	err = cmd.Start()
+// Writes the tail of what was captured to output, and copies it on.
+func (c *capturedOutput) tail(output io.Writer) {
+	if c.file == nil {
+		return
+	}
+	info, err := c.file.Stat()
+	if err != nil {
+		return
+	}
+	if c.copyTo != nil {
+		c.file.Seek(c.offset, os.SEEK_SET)
+		io.CopyN(c.copyTo, c.file, info.Size()-c.offset)
+	}
+	start := info.Size() - MAX_EXIT_OUTPUT
+	if start < c.offset {
+		start = c.offset
+	}
+	data := make([]byte, info.Size()-start)
+	n, _ := c.file.ReadAt(data, start)
+	output.Write(data[:n])
+}
 
-	return cmd, err
+func (c *capturedOutput) close() {
+	if c.temp {
+		c.file.Close()
+	}
 }
 
 // Start a process.
-// Process must manage its own Pidfile.
+// Process must manage its own Pidfile, unless it is supervised.
 func (p *Process) StartProcess() (int, error) {
+	return p.startProcess(nil)
+}
+
+// Starts the process, telling exits when it exits if it is supervised.
+func (p *Process) startProcess(exits chan<- string) (int, error) {
 	cmd, err := p.Spawn(p.Start)
 	if err != nil {
-		Log.Errorf("Error starting process '%v': %v", p.Name, err.Error())
+		Log.Errorf("Error starting process '%v': %v", p.FullName(), err.Error())
 		return 0, err
 	}
 
 	pid := cmd.Process.Pid
 
-	go cmd.Wait()
+	if p.Supervise {
+		p.supervise(cmd, exits)
+	} else {
+		go func() {
+			cmd.Wait()
+			if cmd.ProcessState != nil {
+				p.recordExit(newExitStatus(EXIT_PROGRAM_START,
+					cmd.ProcessState, ""))
+			}
+		}()
+	}
 
 	return pid, err
 }
 
+// Signals that may be used as stop_signal.
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+}
+
+// Parses a signal name such as "TERM" or "SIGQUIT".
+func parseSignal(name string) (syscall.Signal, error) {
+	key := strings.ToUpper(name)
+	if strings.HasPrefix(key, "SIG") {
+		key = key[len("SIG"):]
+	}
+	sig, exists := signals[key]
+	if !exists {
+		return 0, fmt.Errorf("unknown signal '%v'", name)
+	}
+	return sig, nil
+}
+
+// Returns the name of a signal, e.g. "SIGTERM".
+func signalName(sig syscall.Signal) string {
+	for name, s := range signals {
+		if s == sig {
+			return "SIG" + name
+		}
+	}
+	return sig.String()
+}
+
+// The signal StopProcess sends, SIGTERM unless configured otherwise.
+func (p *Process) stopSignal() syscall.Signal {
+	if sig, err := parseSignal(p.StopSignal); err == nil {
+		return sig
+	}
+	return syscall.SIGTERM
+}
+
+// Sends a signal to pid.  Processes are started in their own session, so if
+// pid still leads its process group the whole group is signaled, which
+// takes any workers it forked along.
+func (p *Process) signal(pid int, sig syscall.Signal) error {
+	if pid <= 0 {
+		return fmt.Errorf("invalid pid %d", pid)
+	}
+	if pgid := processGroup(pid); pgid != 0 {
+		return syscall.Kill(-pgid, sig)
+	}
+	return syscall.Kill(pid, sig)
+}
+
+// Returns the process group led by pid, or 0 if pid doesn't lead a group of
+// its own.
+func processGroup(pid int) int {
+	if pid <= 0 {
+		return 0
+	}
+	pgid, err := syscall.Getpgid(pid)
+	if err == nil && pgid == pid && pgid != syscall.Getpgrp() {
+		return pgid
+	}
+	return 0
+}
+
+// Returns whether any process is left in process group pgid.
+func groupRunning(pgid int) bool {
+	return pgid != 0 && syscall.Kill(-pgid, 0) == nil
+}
+
 // Stop a process:
 // Spawn Stop program if configured,
-// otherwise send SIGTERM.
+// otherwise send the stop signal.
 func (p *Process) StopProcess() error {
 	if p.Stop == "" {
 		pid, err := p.Pid()
 		if err != nil {
 			return err
 		}
-		return syscall.Kill(pid, syscall.SIGTERM)
+		return p.signal(pid, p.stopSignal())
 	}
 
-	cmd, err := p.Spawn(p.Stop)
-	if err != nil {
-		return err
-	}
-
-	return cmd.Wait()
+	return p.runCommand(EXIT_PROGRAM_STOP, p.Stop, p.stopTimeout())
 }
 
 // Restart a process:
@@ -152,12 +431,8 @@ func (p *Process) RestartProcess() error {
 		return err
 	}
 
-	cmd, err := p.Spawn(p.Restart)
-	if err != nil {
-		return err
-	}
-
-	return cmd.Wait()
+	return p.runCommand(EXIT_PROGRAM_RESTART, p.Restart,
+		p.restartTimeout())
 }
 
 // Helper method to check if process is running via Pidfile
@@ -185,9 +460,23 @@ func ReadPidFile(path string) (int, error) {
 	return 0, err
 }
 
-// Read pid from Pidfile
+// Read pid from Pidfile, or from the supervised child.  A pid in the Pidfile
+// that is no longer the process gonit recorded is an error.
 func (p *Process) Pid() (int, error) {
-	return ReadPidFile(p.Pidfile)
+	if pid, running := p.supervisedPid(); running {
+		return pid, nil
+	}
+	if p.Supervise && p.Pidfile == "" {
+		return 0, fmt.Errorf("process %q is not running", p.FullName())
+	}
+	pid, err := ReadPidFile(p.Pidfile)
+	if err != nil {
+		return 0, err
+	}
+	if err := p.checkIdentity(pid); err != nil {
+		return 0, err
+	}
+	return pid, nil
 }
 
 // Write pid to a file
@@ -199,98 +488,84 @@ func WritePidFile(pid int, path string) error {
 
 // Write pid to Pidfile
 func (p *Process) SavePid(pid int) error {
-	Log.Debugf("Saving %q pid to file=%s", p.Name, p.Pidfile)
+	Log.Debugf("Saving %q pid to file=%s", p.FullName(), p.Pidfile)
 	return WritePidFile(pid, p.Pidfile)
 }
 
-// If User is configured, lookup and set Uid
-func (p *Process) lookupUid(credential *syscall.Credential) error {
-	if p.Uid == "" {
-		return nil
-	}
+// Looks up the user and group the process runs as.  As with initgroups(3),
+// the process gets the supplementary groups of its user, plus its gid.
+// Returns the user, if one is configured.
+func (p *Process) lookupCredentials(
+	credential *syscall.Credential) (*user.User, error) {
+	credential.Uid = uint32(os.Getuid())
+	credential.Gid = uint32(os.Getgid())
+	credential.Groups = []uint32{}
+
+	var account *user.User
+	if p.Uid != "" {
+		var err error
+		if account, err = LookupUser(p.Uid); err != nil {
+			return nil, err
+		}
+		uid, _ := strconv.Atoi(account.Uid)
+		gid, _ := strconv.Atoi(account.Gid)
+		credential.Uid, credential.Gid = uint32(uid), uint32(gid)
 
-	id, err := user.Lookup(p.Uid)
-	if err != nil {
-		return err
+		groupIds, err := account.GroupIds()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range groupIds {
+			if gid, err := strconv.Atoi(id); err == nil {
+				credential.Groups = append(credential.Groups, uint32(gid))
+			}
+		}
 	}
 
-	uid, _ := strconv.Atoi(id.Uid)
-	gid, _ := strconv.Atoi(id.Gid)
-
-	credential.Uid = uint32(uid)
-
-	if p.Gid == "" {
+	if p.Gid != "" {
+		gid, err := LookupGroupId(p.Gid)
+		if err != nil {
+			return nil, err
+		}
 		credential.Gid = uint32(gid)
+		if account != nil && !containsGid(credential.Groups, credential.Gid) {
+			credential.Groups = append(credential.Groups, credential.Gid)
+		}
 	}
 
-	return nil
+	return account, nil
 }
 
-// If Group is configured, lookup and set Gid
-func (p *Process) lookupGid(credential *syscall.Credential) error {
-	if p.Gid == "" {
-		return nil
-	}
-
-	gid, err := LookupGroupId(p.Gid)
-	if err != nil {
-		return err
-	}
-
-	credential.Gid = uint32(gid)
-
-	if p.Uid == "" {
-		credential.Uid = uint32(os.Getuid())
+func containsGid(gids []uint32, gid uint32) bool {
+	for _, g := range gids {
+		if g == gid {
+			return true
+		}
 	}
-
-	return nil
+	return false
 }
 
-func (p *Process) lookupCredentials(credential *syscall.Credential) error {
-	if err := p.lookupUid(credential); err != nil {
-		return err
-	}
-	if err := p.lookupGid(credential); err != nil {
-		return err
+// Looks up a user by name, or by id if name is a number.
+func LookupUser(name string) (*user.User, error) {
+	account, err := user.Lookup(name)
+	if err != nil {
+		if _, convErr := strconv.Atoi(name); convErr == nil {
+			return user.LookupId(name)
+		}
 	}
-	return nil
+	return account, err
 }
 
-// until we have user.LookupGroupId: http://codereview.appspot.com/4589049
-func LookupGroupId(group string) (int, error) {
-	const (
-		GR_NAME = iota
-		_
-		GR_ID
-	)
-
-	file, err := os.Open("/etc/group")
+// Looks up the id of a group by name, or checks that a numeric id exists.
+func LookupGroupId(name string) (int, error) {
+	group, err := user.LookupGroup(name)
 	if err != nil {
-		return -1, err
-	}
-	defer file.Close()
-	reader := bufio.NewReader(file)
-
-	for {
-		line, _, err := reader.ReadLine()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return 0, err
+		if _, convErr := strconv.Atoi(name); convErr != nil {
+			return -1, err
 		}
-
-		line = bytes.TrimSpace(line)
-
-		if len(line) == 0 || line[0] == '#' {
-			continue
-		}
-
-		fields := strings.Split(string(line), ":")
-		if len(fields) == 4 && fields[GR_NAME] == group {
-			return strconv.Atoi(fields[GR_ID])
+		if group, err = user.LookupGroupId(name); err != nil {
+			return -1, err
 		}
 	}
-
-	return -1, errors.New("group: unknown group " + group)
+	return strconv.Atoi(group.Gid)
 }
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -284,3 +285,33 @@ func (s *ProcessSuite) TestFailExe(c *C) {
 
 	c.Check(false, Equals, process.IsRunning())
 }
+
+// the credentials and environment of a process running as another user
+func (s *ProcessSuite) TestCommandCredentials(c *C) {
+	nobody, err := LookupUser("nobody")
+	if err != nil {
+		c.Skip("no nobody user")
+		return
+	}
+
+	process := &Process{Name: "creds", Uid: "nobody",
+		Env: []string{"HOME=/tmp"}}
+	cmd, err := process.Command("true")
+	c.Assert(err, IsNil)
+
+	credential := cmd.SysProcAttr.Credential
+	c.Check(strconv.Itoa(int(credential.Uid)), Equals, nobody.Uid)
+	c.Check(strconv.Itoa(int(credential.Gid)), Equals, nobody.Gid)
+	groups := []string{}
+	for _, gid := range credential.Groups {
+		groups = append(groups, strconv.Itoa(int(gid)))
+	}
+	c.Check(groups, DeepEquals, []string{nobody.Gid})
+	c.Check(cmd.Env, DeepEquals, []string{"PATH=/bin:/usr/bin:/sbin:/usr/sbin",
+		"HOME=/tmp", "USER=nobody", "LOGNAME=nobody", "GONIT_PROCESS=creds",
+		"GONIT_GROUP="})
+
+	process.Uid = strconv.Itoa(int(credential.Uid))
+	_, err = process.Command("true")
+	c.Check(err, IsNil)
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"reflect"
+)
+
+// A config reload only touches the processes whose config changed.  Control
+// state and collected resource data are keyed by group/process name, so they
+// carry over to the new config for every process that isn't removed.
+
+// Reports what a config reload did, by process group/name.
+type ReloadResult struct {
+	ActionResult
+	// Processes new to the config, which were started.
+	Added []string
+	// Processes no longer in the config, which were stopped.
+	Removed []string
+	// Running processes that were restarted for their new config.
+	Restarted []string
+	// Processes whose config changed without needing a restart.
+	Updated []string
+	// Processes whose config didn't change.
+	Unchanged []string
+}
+
+// Process fields that can change without restarting the process.
+var reloadableFields = map[string]bool{
+	"Description": true,
+	"DependsOn":   true,
+	"Actions":     true,
+	"MonitorMode": true,
+	"Instances":   true,
+	// applied the next time the process is started or stopped
+	"StartTimeout":    true,
+	"StopTimeout":     true,
+	"RestartTimeout":  true,
+	"StopSignal":      true,
+	"StopGracePeriod": true,
+	"Hooks":           true,
+	"Ready":           true,
+}
+
+// Returns the names of the config fields that differ between two versions of
+// a process.
+func (p *Process) changedFields(other *Process) []string {
+	changed := []string{}
+	// not copied, so the fields gonit sets while the process runs aren't read
+	current, updated := reflect.ValueOf(p).Elem(), reflect.ValueOf(other).Elem()
+	typ := current.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		if typ.Field(i).PkgPath != "" {
+			continue // unexported
+		}
+		if !reflect.DeepEqual(current.Field(i).Interface(),
+			updated.Field(i).Interface()) {
+			changed = append(changed, typ.Field(i).Name)
+		}
+	}
+	return changed
+}
+
+// Returns whether any of the changed fields only applies when the process is
+// started.
+func needsRestart(changedFields []string) bool {
+	for _, field := range changedFields {
+		if !reloadableFields[field] {
+			return true
+		}
+	}
+	return false
+}
+
+// Replaces the current config with a new one.  Processes that were removed
+// are stopped and forgotten, running processes with changed start settings
+// are restarted and new processes are started.  Everything else is left
+// running as it is.
+func (c *Control) Reload(config *ConfigManager, r *ReloadResult) {
+	previous := *c.Config()
+	oldConfig := &previous
+	restart := []string{}
+
+	for _, pg := range oldConfig.sortedGroups() {
+		for _, process := range pg.sortedProcesses() {
+			name := process.FullName()
+			newProcess, err := config.FindProcess(name)
+			if err != nil {
+				Log.Infof("Process %q was removed", name)
+				r.Removed = append(r.Removed, name)
+				c.reloadAction(process, ACTION_STOP, &r.ActionResult)
+				c.forget(process)
+				continue
+			}
+
+			newProcess.inheritRuntime(process)
+			changed := process.changedFields(newProcess)
+			if len(changed) == 0 {
+				r.Unchanged = append(r.Unchanged, name)
+				continue
+			}
+			Log.Infof("Process %q changed %v", name, changed)
+			if needsRestart(changed) && process.IsRunning() {
+				r.Restarted = append(r.Restarted, name)
+				// stop with the old config, which knows the old pidfile
+				c.reloadAction(process, ACTION_STOP, &r.ActionResult)
+				restart = append(restart, name)
+			} else {
+				r.Updated = append(r.Updated, name)
+			}
+		}
+	}
+
+	c.configLock.Lock()
+	*c.ConfigManager = *config
+	c.configLock.Unlock()
+
+	if c.EventMonitor != nil {
+		if err := c.EventMonitor.Reload(c.ConfigManager, c); err != nil {
+			Log.Errorf("Error reloading event monitor: %v", err)
+		}
+	}
+
+	for _, name := range restart {
+		process, _ := c.ConfigManager.FindProcess(name)
+		c.reloadAction(process, ACTION_START, &r.ActionResult)
+	}
+
+	for _, pg := range c.ConfigManager.sortedGroups() {
+		for _, process := range pg.sortedProcesses() {
+			name := process.FullName()
+			if _, err := oldConfig.FindProcess(name); err == nil {
+				continue
+			}
+			Log.Infof("Process %q was added", name)
+			r.Added = append(r.Added, name)
+			c.callAction(name, &r.ActionResult, NewControlAction(ACTION_START))
+		}
+	}
+
+	if err := c.PersistStates(c.States); err != nil {
+		Log.Errorf("Error persisting state: '%v'", err.Error())
+	}
+}
+
+// Starts or stops just the given process, leaving the processes it depends
+// on and those depending on it alone.
+func (c *Control) reloadAction(process *Process, method int, r *ActionResult) {
+	action := NewControlAction(method)
+	action.scope = scopeRestartGroup
+	err := c.invoke(process, 0, func() error {
+		switch method {
+		case ACTION_START:
+			c.doStart(process, action)
+		case ACTION_STOP:
+			c.doStop(process, action)
+		}
+		if len(action.errors) != 0 {
+			return action.errors[0]
+		}
+		return nil
+	})
+
+	r.Total++
+	r.Steps = append(r.Steps, action.steps...)
+	if err != nil {
+		r.Errors++
+		if _, ok := err.(*TimeoutError); ok {
+			r.Timeouts++
+		}
+		Log.Error(err.Error())
+	}
+}
+
+// Drops the control state, cgroup and resource data of a removed process.
+func (c *Control) forget(process *Process) {
+	c.statesLock.Lock()
+	delete(c.States, process.FullName())
+	c.statesLock.Unlock()
+	process.removeCgroup()
+	if c.EventMonitor != nil {
+		c.EventMonitor.CleanDataForProcess(process)
+	}
+}
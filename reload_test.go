@@ -0,0 +1,120 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit_test
+
+import (
+	. "github.com/cloudfoundry/gonit"
+	"github.com/cloudfoundry/gonit/test/helper"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+)
+
+type ReloadSuite struct{}
+
+var _ = Suite(&ReloadSuite{})
+
+func (s *ReloadSuite) TearDownTest(c *C) {
+	os.Remove(gonitPersistFile)
+}
+
+func (s *ReloadSuite) TestReload(c *C) {
+	ctl := &Control{
+		ConfigManager: &ConfigManager{
+			Settings: &Settings{PersistFile: gonitPersistFile},
+		},
+		EventMonitor: &FakeEventMonitor{},
+	}
+
+	keep := helper.NewTestProcess("reload_keep", nil, false)
+	change := helper.NewTestProcess("reload_change", nil, false)
+	remove := helper.NewTestProcess("reload_remove", nil, false)
+	add := helper.NewTestProcess("reload_add", nil, false)
+	for _, process := range []*Process{keep, change, remove, add} {
+		defer helper.Cleanup(process)
+	}
+
+	for _, process := range []*Process{keep, change, remove} {
+		c.Assert(ctl.Config().AddProcess(groupName, process), IsNil)
+		c.Assert(ctl.DoAction(process.FullName(),
+			NewControlAction(ACTION_START)), IsNil)
+		c.Assert(process.IsRunning(), Equals, true)
+	}
+	keepPid, _ := keep.Pid()
+	changePid, _ := change.Pid()
+
+	config := &ConfigManager{
+		Settings:      ctl.ConfigManager.Settings,
+		ProcessGroups: map[string]*ProcessGroup{},
+	}
+	newKeep, newChange := *keep, *change
+	newKeep.Description = "only the description changed"
+	newChange.Env = append(newChange.Env, "RELOADED=1")
+	for _, process := range []*Process{&newKeep, &newChange, add} {
+		c.Assert(config.AddProcess(groupName, process), IsNil)
+	}
+
+	result := &ReloadResult{}
+	ctl.Reload(config, result)
+
+	c.Check(result.Errors, Equals, 0)
+	c.Check(result.Added, DeepEquals, []string{add.FullName()})
+	c.Check(result.Removed, DeepEquals, []string{remove.FullName()})
+	c.Check(result.Restarted, DeepEquals, []string{change.FullName()})
+	c.Check(result.Updated, DeepEquals, []string{keep.FullName()})
+
+	c.Check(remove.IsRunning(), Equals, false)
+	_, exists := ctl.States[remove.FullName()]
+	c.Check(exists, Equals, false)
+
+	pid, _ := newKeep.Pid()
+	c.Check(pid, Equals, keepPid)
+	c.Check(ctl.State(&newKeep).Starts, Equals, 1)
+
+	pid, _ = newChange.Pid()
+	c.Check(pid, Not(Equals), changePid)
+	c.Check(ctl.State(&newChange).Starts, Equals, 2)
+
+	c.Check(add.IsRunning(), Equals, true)
+}
+
+func (s *ReloadSuite) TestReloadWaitsForJobs(c *C) {
+	dir := c.MkDir()
+	configManager := &ConfigManager{
+		Settings: &Settings{PersistFile: gonitPersistFile},
+	}
+	api := NewAPI(configManager)
+	api.Control.EventMonitor = &FakeEventMonitor{}
+	pidfile := filepath.Join(dir, "slow.pid")
+	process := &Process{
+		Name:    "slow",
+		Pidfile: pidfile,
+		Start:   "sleep 0.5; echo $$ > " + pidfile + "; exec sleep 60",
+		Shell:   true,
+	}
+	c.Assert(api.Control.Config().AddProcess(groupName, process), IsNil)
+	defer api.StopAll(nil, &ActionResult{})
+
+	job := &Job{}
+	c.Assert(api.SubmitJob(JobRequest{"StartProcess", process.FullName()},
+		job), IsNil)
+
+	config := &ConfigManager{
+		Settings:      configManager.Settings,
+		ProcessGroups: map[string]*ProcessGroup{},
+	}
+	updated := &Process{
+		Name:        process.Name,
+		Description: "reloaded",
+		Pidfile:     pidfile,
+		Start:       process.Start,
+		Shell:       true,
+	}
+	c.Assert(config.AddProcess(groupName, updated), IsNil)
+	api.Control.Reload(config, &ReloadResult{})
+
+	// the config was only replaced once the job was done with it
+	c.Check(updated.IsRunning(), Equals, true)
+	c.Assert(api.WaitJob(JobWait{Id: job.Id}, job), IsNil)
+	c.Check(job.State, Equals, JOB_DONE)
+}
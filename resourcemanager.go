@@ -50,6 +50,9 @@ type ResourceManager struct {
 type ResourceHolder struct {
 	processName     string
 	resourceName    string
+	cgroup          string
+	oomKills        uint64
+	hasOomKills     bool
 	dataTimestamps  []*DataTimestamp
 	firstEntryIndex int64
 	maxDataToStore  int64
@@ -73,11 +76,18 @@ const (
 const (
 	CPU_PERCENT_NAME = "cpu_percent"
 	MEMORY_USED_NAME = "memory_used"
+	OOM_KILL_NAME    = "oom_kill"
 )
 
 var validResourceNames = map[string]bool{
 	MEMORY_USED_NAME: true,
 	CPU_PERCENT_NAME: true,
+	OOM_KILL_NAME:    true,
+}
+
+// Resources that can only be read from the cgroup of a process.
+var cgroupResourceNames = map[string]bool{
+	OOM_KILL_NAME: true,
 }
 
 // Cleans data from ResourceManager.
@@ -89,7 +99,7 @@ func (r *ResourceManager) CleanData() {
 // Cleans up the resource data used for a process's event monitors.
 func (r *ResourceManager) CleanDataForProcess(p *Process) {
 	for _, resourceHolder := range r.resourceHolders {
-		if resourceHolder.processName == p.Name {
+		if resourceHolder.processName == p.FullName() {
 			resourceHolder.dataTimestamps = []*DataTimestamp{}
 			resourceHolder.firstEntryIndex = 0
 		}
@@ -142,12 +152,14 @@ func (r *ResourceManager) getResourceHolder(
 	for _, resourceHolder := range r.resourceHolders {
 		if resourceHolder.processName == processName &&
 			resourceHolder.resourceName == resourceName {
+			resourceHolder.cgroup = parsedEvent.cgroup
 			return resourceHolder
 		}
 	}
 	resourceHolder := &ResourceHolder{
 		processName:  processName,
 		resourceName: resourceName,
+		cgroup:       parsedEvent.cgroup,
 	}
 	interval := float64(parsedEvent.interval.Seconds())
 	duration := float64(parsedEvent.duration.Seconds())
@@ -197,6 +209,15 @@ func averageDataTimestampArray(array []*DataTimestamp) uint64 {
 	return sum / uint64(len(array))
 }
 
+// Returns the sum of an array of DataTimestamps.
+func sumDataTimestampArray(array []*DataTimestamp) uint64 {
+	sum := uint64(0)
+	for _, val := range array {
+		sum += val.data
+	}
+	return sum
+}
+
 // Gets all entries in a resource holder since a nanosecond unix timestamp.
 func (r *ResourceHolder) getEntriesSince(
 	nanosecondStart int64) []*DataTimestamp {
@@ -234,6 +255,8 @@ func (r *ResourceHolder) getDurationData(entries []*DataTimestamp,
 			return averageDataTimestampArray(entries), nil
 		} else if resourceName == CPU_PERCENT_NAME {
 			return r.calculateProcPercent(first, last)
+		} else if resourceName == OOM_KILL_NAME {
+			return sumDataTimestampArray(entries), nil
 		}
 	}
 	return 0, nil
@@ -322,7 +345,8 @@ func (r *ResourceManager) ParseAmount(resourceName string,
 				units, resourceName)
 		}
 		return amountUi, nil
-	} else if resourceName == CPU_PERCENT_NAME {
+	} else if resourceName == CPU_PERCENT_NAME ||
+		resourceName == OOM_KILL_NAME {
 		amountUi, err := strconv.ParseUint(amount, 10, 64)
 		return amountUi, err
 	}
@@ -353,6 +377,13 @@ func (r *ResourceHolder) saveData(dataToSave uint64) {
 // Gets the data for a resource and saves it to the ResourceHolder.
 func (r *ResourceManager) gather(pid int,
 	resourceHolder *ResourceHolder) error {
+	if resourceHolder.cgroup != "" {
+		return r.gatherCgroup(resourceHolder)
+	}
+	if cgroupResourceNames[resourceHolder.resourceName] {
+		return fmt.Errorf("Resource %v of process %v needs a cgroup.",
+			resourceHolder.resourceName, resourceHolder.processName)
+	}
 	if resourceHolder.resourceName == MEMORY_USED_NAME {
 		mem, err := r.sigarInterface.getMemResident(pid)
 		if err != nil {
@@ -369,6 +400,36 @@ func (r *ResourceManager) gather(pid int,
 	return nil
 }
 
+// Gets the data for a resource of everything in a process' cgroup, so the
+// children of the process are counted too.  oom_kill is saved as the number
+// of kills since the last time it was gathered.
+func (r *ResourceManager) gatherCgroup(resourceHolder *ResourceHolder) error {
+	var data uint64
+	var err error
+	switch resourceHolder.resourceName {
+	case MEMORY_USED_NAME:
+		data, err = getCgroupMemory(resourceHolder.cgroup)
+	case CPU_PERCENT_NAME:
+		data, err = getCgroupProcTime(resourceHolder.cgroup)
+	case OOM_KILL_NAME:
+		var oomKills uint64
+		if oomKills, err = getCgroupOomKills(resourceHolder.cgroup); err != nil {
+			break
+		}
+		// the kills are logged and alerted about by the watcher
+		if resourceHolder.hasOomKills {
+			data = oomKillsSince(resourceHolder.oomKills, oomKills)
+		}
+		resourceHolder.oomKills, resourceHolder.hasOomKills = oomKills, true
+	}
+	if err != nil {
+		return fmt.Errorf("Couldnt get %v from cgroup '%v': %v",
+			resourceHolder.resourceName, resourceHolder.cgroup, err)
+	}
+	resourceHolder.saveData(data)
+	return nil
+}
+
 // Checks to see if a resource is a valid resource.
 func (r *ResourceManager) IsValidResourceName(resourceName string) bool {
 	if _, hasKey := validResourceNames[resourceName]; hasKey {
@@ -0,0 +1,220 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// The watcher starts a monitored process that it finds not running.  A
+// restart policy keeps it from restarting a process that keeps crashing
+// forever:
+//
+//   worker:
+//     restarts:
+//       max: 5
+//       within: 10m
+//       backoff: 1s
+//       max_backoff: 1m
+//
+// Each restart within the window is held back twice as long as the one
+// before, starting from backoff and up to max_backoff, give or take a
+// quarter so that processes crashing together aren't restarted together.
+// A process found not running while it is held back is restarted by the
+// first check after that.  Once a process has been restarted max times
+// within the window it has failed: an alert is sent and the watcher leaves
+// it alone until it is started by hand, through the API.  Starts and
+// restarts triggered by event rules count against the policy too.  Without a
+// policy a process is restarted whenever it is found not running.
+
+const (
+	DEFAULT_RESTART_WINDOW = "5m"
+	DEFAULT_MAX_BACKOFF    = "1m"
+	RESTART_JITTER         = 0.25
+)
+
+type RestartPolicy struct {
+	Max        int
+	Within     string
+	Backoff    string
+	MaxBackoff string `yaml:"max_backoff"`
+}
+
+// What the watcher has done to restart a process.  Times are in
+// milliseconds since the epoch.
+type RestartState struct {
+	// The restarts within the window, oldest first.
+	Restarts []int64
+	// When the process may be restarted again.
+	Next int64
+	// When the process failed, 0 if it hasn't.
+	Failed int64
+}
+
+// What the watcher does about a process found not running.
+const (
+	restartNow = iota
+	restartLater
+	restartFail
+	restartFailed
+)
+
+func (p *Process) validateRestarts() error {
+	if p.Restarts == nil {
+		return nil
+	}
+	errs := ConfigErrors{}
+	what := fmt.Sprintf("Process %v restarts", p.FullName())
+	if p.Restarts.Max < 0 {
+		errs.add(fmt.Errorf("%v max must not be negative.", what))
+	}
+	errs.add(validateTimeout(what+" within", p.Restarts.Within))
+	errs.add(validateTimeout(what+" backoff", p.Restarts.Backoff))
+	errs.add(validateTimeout(what+" max_backoff", p.Restarts.MaxBackoff))
+	return errs.errOrNil()
+}
+
+func (r *RestartPolicy) window() time.Duration {
+	return parseTimeout(r.Within, DEFAULT_RESTART_WINDOW)
+}
+
+// How long to hold back the restart after the given number of restarts
+// within the window, before jitter.
+func (r *RestartPolicy) backoff(restarts int) time.Duration {
+	delay := parseTimeout(r.Backoff, "0s")
+	max := parseTimeout(r.MaxBackoff, DEFAULT_MAX_BACKOFF)
+	for i := 1; i < restarts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+func jitter(delay time.Duration) time.Duration {
+	return delay + time.Duration(
+		float64(delay)*RESTART_JITTER*(2*rand.Float64()-1))
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// Decides what to do about a process found not running at now, and returns
+// the state of its restarts after doing it.
+func (r *RestartPolicy) decide(state RestartState, now time.Time) (int,
+	RestartState) {
+	if state.Failed != 0 {
+		return restartFailed, state
+	}
+	ms := millis(now)
+	if ms < state.Next {
+		return restartLater, state
+	}
+
+	since := ms - int64(r.window()/time.Millisecond)
+	restarts := []int64{}
+	for _, restart := range state.Restarts {
+		if restart > since {
+			restarts = append(restarts, restart)
+		}
+	}
+	state.Restarts = restarts
+	if r.Max > 0 && len(restarts) >= r.Max {
+		state.Failed = ms
+		return restartFail, state
+	}
+
+	state.Restarts = append(state.Restarts, ms)
+	delay := jitter(r.backoff(len(state.Restarts)))
+	state.Next = ms + int64(delay/time.Millisecond)
+	return restartNow, state
+}
+
+// Returns the state of the restarts of the process, nil if there is none.
+func (p *Process) RestartState() *RestartState {
+	var restart *RestartState
+	p.withState(func(state *ProcessState) {
+		restart = state.Restart
+	})
+	return restart
+}
+
+// Replaces the state of the restarts of the process.  States are replaced
+// rather than changed, so the one returned by RestartState stays as it is.
+func (p *Process) setRestartState(restart *RestartState) {
+	p.withState(func(state *ProcessState) {
+		state.Restart = restart
+	})
+}
+
+// Forgets the restarts of the process when it is started by hand, so a
+// failed process is watched again.
+func (p *Process) resetRestarts() {
+	if state := p.RestartState(); state != nil {
+		if state.Failed != 0 {
+			Log.Infof("Process %q is no longer failed", p.FullName())
+		}
+		p.setRestartState(nil)
+	}
+}
+
+// Returns whether the watcher or an event rule may restart a process,
+// recording the restart if so.  Fails the process if it has been restarted
+// too often.
+func (c *Control) restartAllowed(process *Process) bool {
+	policy := process.Restarts
+	if policy == nil {
+		return true
+	}
+	var decision int
+	restart := RestartState{}
+	c.withState(process, func(state *ProcessState) {
+		if state.Restart != nil {
+			restart = *state.Restart
+		}
+		decision, restart = policy.decide(restart, time.Now())
+		state.Restart = &restart
+	})
+
+	switch decision {
+	case restartLater:
+		Log.Debugf("Process %q restart held back until %v", process.FullName(),
+			time.Unix(0, restart.Next*int64(time.Millisecond)))
+	case restartFail:
+		c.restartFailed(process)
+	case restartFailed:
+		Log.Debugf("Process %q has failed, not restarting", process.FullName())
+	}
+	return decision == restartNow
+}
+
+// Alerts that the process has failed, and persists it.
+func (c *Control) restartFailed(process *Process) {
+	policy := process.Restarts
+	description := fmt.Sprintf("Process %q failed: restarted %d times "+
+		"within %v", process.FullName(), policy.Max, policy.window())
+	Log.Error(description)
+
+	if err := c.PersistStates(c.States); err != nil {
+		Log.Errorf("Error persisting state: '%v'", err.Error())
+	}
+
+	alertMessage := &AlertMessage{
+		Action: "alert",
+		Rule: fmt.Sprintf("restarts >= %d within %v", policy.Max,
+			policy.window()),
+		Service:     process.FullName(),
+		Description: description,
+		Date:        time.Now(),
+	}
+	alertMessage.LastExit, alertMessage.LastCommand = process.LastExits()
+	err := sendAlertMessage(c.ConfigManager.Settings, alertMessage)
+	if err != nil {
+		Log.Errorf("Error sending alert for process %q: %v",
+			process.FullName(), err)
+	}
+}
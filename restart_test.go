@@ -0,0 +1,180 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"bytes"
+	"encoding/json"
+	. "launchpad.net/gocheck"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type RestartSuite struct {
+	dir string
+}
+
+var _ = Suite(&RestartSuite{})
+
+func (s *RestartSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *RestartSuite) TestBackoff(c *C) {
+	policy := &RestartPolicy{Backoff: "1s", MaxBackoff: "3s"}
+	c.Check(policy.backoff(1), Equals, time.Second)
+	c.Check(policy.backoff(2), Equals, 2*time.Second)
+	c.Check(policy.backoff(3), Equals, 3*time.Second)
+	c.Check(policy.backoff(30), Equals, 3*time.Second)
+
+	policy = &RestartPolicy{}
+	c.Check(policy.backoff(5), Equals, time.Duration(0))
+
+	for i := 0; i < 100; i++ {
+		delay := jitter(time.Second)
+		c.Check(delay >= 750*time.Millisecond, Equals, true)
+		c.Check(delay <= 1250*time.Millisecond, Equals, true)
+	}
+}
+
+func (s *RestartSuite) TestDecide(c *C) {
+	policy := &RestartPolicy{Max: 2, Within: "1m", Backoff: "10s"}
+	now := time.Unix(1000, 0)
+	state := RestartState{}
+
+	decision, state := policy.decide(state, now)
+	c.Check(decision, Equals, restartNow)
+	c.Check(state.Restarts, DeepEquals, []int64{millis(now)})
+	c.Check(state.Next >= millis(now.Add(7500*time.Millisecond)), Equals, true)
+
+	// held back
+	decision, state = policy.decide(state, now.Add(time.Second))
+	c.Check(decision, Equals, restartLater)
+
+	now = now.Add(15 * time.Second)
+	decision, state = policy.decide(state, now)
+	c.Check(decision, Equals, restartNow)
+	c.Check(len(state.Restarts), Equals, 2)
+	c.Check(state.Next >= millis(now.Add(15*time.Second)), Equals, true)
+
+	now = now.Add(30 * time.Second)
+	decision, state = policy.decide(state, now)
+	c.Check(decision, Equals, restartFail)
+	c.Check(state.Failed, Equals, millis(now))
+
+	// failed until reset, however long it is
+	decision, _ = policy.decide(state, now.Add(time.Hour))
+	c.Check(decision, Equals, restartFailed)
+
+	// restarts outside the window are forgotten
+	state.Failed = 0
+	decision, state = policy.decide(state, now.Add(20*time.Second))
+	c.Check(decision, Equals, restartNow)
+	c.Check(len(state.Restarts), Equals, 2)
+}
+
+func (s *RestartSuite) TestFailed(c *C) {
+	socket := filepath.Join(s.dir, "alerts.sock")
+	listener, err := net.Listen("unix", socket)
+	c.Assert(err, IsNil)
+	defer listener.Close()
+
+	settings := &Settings{
+		PersistFile:    filepath.Join(s.dir, "state.yml"),
+		AlertTransport: UNIX_SOCKET_TRANSPORT,
+		SocketFile:     socket,
+	}
+	control := &Control{ConfigManager: &ConfigManager{Settings: settings}}
+	process := &Process{Name: "flapping",
+		Restarts: &RestartPolicy{Max: 2, Within: "1m"}}
+	control.Config().AddProcess("restart", process)
+	// as the watcher does when it checks the process
+	control.monitorActivate(process)
+
+	c.Check(control.restartAllowed(process), Equals, true)
+	c.Check(control.restartAllowed(process), Equals, true)
+
+	alerts := make(chan *AlertMessage)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(alerts)
+			return
+		}
+		defer conn.Close()
+		alert := &AlertMessage{}
+		if json.NewDecoder(conn).Decode(alert) != nil {
+			alert = nil
+		}
+		alerts <- alert
+	}()
+	c.Check(control.restartAllowed(process), Equals, false)
+	alert := <-alerts
+	c.Assert(alert, NotNil)
+	c.Check(alert.Service, Equals, "restart/flapping")
+	c.Check(alert.Description, Matches, ".* failed: restarted 2 times .*")
+	c.Check(control.restartAllowed(process), Equals, false)
+
+	// the failure was persisted
+	control.States = nil
+	c.Assert(control.LoadPersistState(), IsNil)
+	c.Assert(process.RestartState(), NotNil)
+	c.Check(process.RestartState().Failed, Not(Equals), int64(0))
+
+	status := &ProcessStatus{}
+	control.processSummary(process, &status.Summary)
+	var out bytes.Buffer
+	status.Print(&out)
+	c.Check(out.String(), Matches, `(?s).*status +failed\n.*`+
+		`restarts +failed at .* after 2 restarts\n.*`)
+
+	// until it is started by hand
+	process.resetRestarts()
+	c.Check(process.RestartState(), IsNil)
+	c.Check(control.restartAllowed(process), Equals, true)
+}
+
+func (s *RestartSuite) TestReset(c *C) {
+	started := filepath.Join(s.dir, "started")
+	configManager := &ConfigManager{
+		Settings: &Settings{PersistFile: filepath.Join(s.dir, "state.yml")},
+	}
+	api := NewAPI(configManager)
+	api.Control.EventMonitor = &EventMonitor{resourceManager: resourceManager}
+	process := &Process{Name: "failed", Start: "touch " + started,
+		Shell: true, StartTimeout: "100ms",
+		Restarts: &RestartPolicy{Max: 2, Within: "1m"}}
+	api.Control.Config().AddProcess("restart", process)
+	api.Control.State(process)
+	failed := &RestartState{Failed: millis(time.Now())}
+	process.setRestartState(failed)
+
+	// held back by the policy when triggered by an event rule
+	err := api.Control.DoAction(process.FullName(),
+		newRuleAction(ACTION_RESTART))
+	c.Check(err, IsNil)
+	c.Check(process.RestartState(), DeepEquals, failed)
+	_, err = os.Stat(started)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	// left failed by actions gonit takes itself
+	api.Control.DoAction(process.FullName(), NewControlAction(ACTION_START))
+	c.Check(process.RestartState(), DeepEquals, failed)
+
+	// started again by hand
+	api.StartProcess(process.FullName(), &ActionResult{})
+	c.Check(process.RestartState(), IsNil)
+}
+
+func (s *RestartSuite) TestValidate(c *C) {
+	process := &Process{Name: "worker", Restarts: &RestartPolicy{Max: -1,
+		Within: "soon", Backoff: "1s"}}
+	c.Check(process.validateRestarts(), ErrorMatches,
+		"(?s).*restarts max must not be negative.*restarts within.*")
+
+	process.Restarts = &RestartPolicy{Max: 5, Within: "10m", Backoff: "1s",
+		MaxBackoff: "1m"}
+	c.Check(process.validateRestarts(), IsNil)
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"fmt"
+	"time"
+)
+
+// A group with a rolling strategy is restarted a batch of processes at a
+// time, in name order, rather than all at once:
+//
+//   rolling:
+//     batch: 2
+//     pause: 10s
+//
+// The next batch is only restarted once every process of the batch before
+// has started, including passing its readiness probes, and after the pause
+// is still running and ready.  The restart stops at the first process that
+// fails, leaving the rest of the group alone.  It is meant for groups of
+// processes that don't depend on each other, such as stateless workers.
+// Each batch is restarted along with the processes depending on it, even
+// those already restarted with an earlier batch.
+
+const DEFAULT_ROLLING_BATCH = 1
+
+type Rolling struct {
+	Batch int
+	Pause string
+}
+
+func (pg *ProcessGroup) validateRolling() error {
+	if pg.Rolling == nil {
+		return nil
+	}
+	errs := ConfigErrors{}
+	what := fmt.Sprintf("Group %v rolling", pg.Name)
+	if pg.Rolling.Batch < 0 {
+		errs.add(fmt.Errorf("%v batch must not be negative.", what))
+	}
+	errs.add(validateTimeout(what+" pause", pg.Rolling.Pause))
+	return errs.errOrNil()
+}
+
+func (r *Rolling) batch() int {
+	if r.Batch == 0 {
+		return DEFAULT_ROLLING_BATCH
+	}
+	return r.Batch
+}
+
+func (r *Rolling) pause() time.Duration {
+	return parseTimeout(r.Pause, "0s")
+}
+
+// Restarts the processes of the group a batch at a time, recording in r
+// which were restarted and which weren't.
+func (c *Control) rollingRestart(group *ProcessGroup, r *ActionResult,
+	action *ControlAction) {
+	processes := group.sortedProcesses()
+	size := group.Rolling.batch()
+
+	for i := 0; i < len(processes); i += size {
+		batch := processes[i:]
+		if len(batch) > size {
+			batch = batch[:size]
+		}
+		if !c.restartBatch(batch, group.Rolling, r, action.batch()) {
+			for _, process := range processes[i+len(batch):] {
+				r.NotRestarted = append(r.NotRestarted, process.FullName())
+			}
+			Log.Warnf("Rolling restart of group %q stopped, %d processes "+
+				"not restarted", group.Name, len(r.NotRestarted))
+			return
+		}
+	}
+}
+
+// Restarts a batch of processes, and returns whether they are all healthy
+// so the rolling restart can go on.
+func (c *Control) restartBatch(batch []*Process, rolling *Rolling,
+	r *ActionResult, action *ControlAction) bool {
+	if action.isCancelled() {
+		for _, process := range batch {
+			r.NotRestarted = append(r.NotRestarted, process.FullName())
+		}
+		return false
+	}
+
+	names := []string{}
+	for _, process := range batch {
+		names = append(names, process.FullName())
+	}
+	// the batch is sorted by name, as the errors are
+	errs := c.callActions(names, r, action)
+	healthy := true
+	for i, err := range errs {
+		if err != nil {
+			r.NotRestarted = append(r.NotRestarted, names[i])
+			healthy = false
+		} else {
+			r.Restarted = append(r.Restarted, names[i])
+		}
+	}
+
+	if healthy && rolling.pause() != 0 {
+		time.Sleep(rolling.pause())
+		for _, process := range batch {
+			if !process.IsRunning() || !process.isReady() {
+				action.step(process, "not healthy %v after restart",
+					rolling.pause())
+				r.Errors++
+				healthy = false
+			}
+		}
+	}
+	// the steps of the restarts are recorded by callActions, those taken on
+	// the batch itself are recorded here
+	action.Lock()
+	r.Steps = append(r.Steps, action.steps...)
+	action.Unlock()
+	return healthy
+}
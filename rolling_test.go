@@ -0,0 +1,160 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit_test
+
+import (
+	. "github.com/cloudfoundry/gonit"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type RollingSuite struct {
+	dir       string
+	api       *API
+	group     *ProcessGroup
+	processes []*Process
+}
+
+var _ = Suite(&RollingSuite{})
+
+const rollingGroup = "rolling"
+
+func (s *RollingSuite) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+func (s *RollingSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+
+	configManager := &ConfigManager{
+		Settings: &Settings{PersistFile: s.path("state.yml")},
+	}
+	s.api = NewAPI(configManager)
+	s.api.Control.EventMonitor = &FakeEventMonitor{}
+	s.processes = nil
+	for _, name := range []string{"a", "b", "c", "d"} {
+		// fails to start or crashes soon after once told to
+		process := &Process{
+			Name:    name,
+			Pidfile: s.path(name + ".pid"),
+			Start: "test -f " + s.path(name+".fail") + " && exit 1; " +
+				"sleep 0.1; echo " + name + " >> " + s.path("started") +
+				"; echo $$ > " + s.path(name+".pid") + "; test -f " +
+				s.path(name+".crash") + " && exec sleep 0.2; exec sleep 60",
+			Shell:        true,
+			StartTimeout: "1s",
+		}
+		c.Assert(s.api.Control.Config().AddProcess(rollingGroup, process),
+			IsNil)
+		s.processes = append(s.processes, process)
+	}
+	s.group = configManager.ProcessGroups[rollingGroup]
+
+	c.Assert(s.api.StartGroup(rollingGroup, &ActionResult{}), IsNil)
+	os.Remove(s.path("started"))
+}
+
+func (s *RollingSuite) TearDownTest(c *C) {
+	s.api.StopGroup(rollingGroup, &ActionResult{})
+}
+
+func (s *RollingSuite) started(c *C) []string {
+	data, err := ioutil.ReadFile(s.path("started"))
+	c.Assert(err, IsNil)
+	return strings.Fields(string(data))
+}
+
+func (s *RollingSuite) pids(c *C, processes []*Process) []int {
+	pids := []int{}
+	for _, process := range processes {
+		pid, err := process.Pid()
+		c.Assert(err, IsNil)
+		pids = append(pids, pid)
+	}
+	return pids
+}
+
+func (s *RollingSuite) touch(c *C, name string) {
+	c.Assert(ioutil.WriteFile(s.path(name), []byte{}, 0644), IsNil)
+}
+
+func (s *RollingSuite) TestBatches(c *C) {
+	s.group.Rolling = &Rolling{Batch: 2}
+
+	result := &ActionResult{}
+	c.Assert(s.api.RestartGroup(rollingGroup, result), IsNil)
+	c.Check(result.Errors, Equals, 0)
+	c.Check(result.Restarted, DeepEquals, []string{"rolling/a", "rolling/b",
+		"rolling/c", "rolling/d"})
+	c.Check(len(result.NotRestarted), Equals, 0)
+
+	// a and b were started before c and d
+	started := s.started(c)
+	c.Assert(len(started), Equals, 4)
+	first := started[:2]
+	sort.Strings(first)
+	c.Check(first, DeepEquals, []string{"a", "b"})
+	for _, process := range s.processes {
+		c.Check(process.IsRunning(), Equals, true)
+	}
+
+	// each process was stopped and started, without a pause in between
+	c.Check(len(result.Steps), Equals, 12)
+	for i, step := range result.Steps {
+		message := []string{"sending SIGTERM", "starting", "started"}[i%3]
+		c.Check(step.Message, Equals, message)
+	}
+}
+
+func (s *RollingSuite) TestStopOnFailure(c *C) {
+	s.group.Rolling = &Rolling{Batch: 1}
+	pids := s.pids(c, s.processes[2:])
+	s.touch(c, "b.fail")
+
+	result := &ActionResult{}
+	c.Assert(s.api.RestartGroup(rollingGroup, result), IsNil)
+	c.Check(result.Errors, Equals, 1)
+	c.Check(result.Restarted, DeepEquals, []string{"rolling/a"})
+	c.Check(result.NotRestarted, DeepEquals, []string{"rolling/b", "rolling/c",
+		"rolling/d"})
+	c.Check(s.started(c), DeepEquals, []string{"a"})
+
+	// the rest of the group was left alone
+	c.Check(s.processes[1].IsRunning(), Equals, false)
+	c.Check(s.pids(c, s.processes[2:]), DeepEquals, pids)
+}
+
+func (s *RollingSuite) TestHealthGate(c *C) {
+	s.group.Rolling = &Rolling{Batch: 2, Pause: "500ms"}
+	s.touch(c, "b.crash")
+
+	result := &ActionResult{}
+	c.Assert(s.api.RestartGroup(rollingGroup, result), IsNil)
+	c.Check(result.Errors, Equals, 1)
+	c.Check(result.Restarted, DeepEquals, []string{"rolling/a", "rolling/b"})
+	c.Check(result.NotRestarted, DeepEquals, []string{"rolling/c",
+		"rolling/d"})
+	c.Check(result.Steps[len(result.Steps)-1], DeepEquals,
+		ActionStep{"rolling/b", "not healthy 500ms after restart"})
+}
+
+func (s *RollingSuite) TestDependents(c *C) {
+	s.group.Rolling = &Rolling{Batch: 1}
+	s.processes[1].DependsOn = []string{"a"}
+
+	result := &ActionResult{}
+	c.Assert(s.api.RestartGroup(rollingGroup, result), IsNil)
+	c.Check(result.Errors, Equals, 0)
+	c.Check(result.Restarted, DeepEquals, []string{"rolling/a", "rolling/b",
+		"rolling/c", "rolling/d"})
+
+	// b was restarted along with a, and again in its own batch
+	c.Check(s.started(c), DeepEquals, []string{"a", "b", "b", "c", "d"})
+	for _, process := range s.processes {
+		c.Check(process.IsRunning(), Equals, true)
+	}
+}
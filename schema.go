@@ -0,0 +1,358 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// goyaml silently drops keys it doesn't know about and values of the wrong
+// type, so a typo such as 'user' instead of 'uid' would leave a process
+// running as root.  Every config file is checked against the Go types it is
+// loaded into before it is unmarshaled, and the keys and values that don't
+// fit are taken out of it, so they can neither fail the unmarshaling nor be
+// half applied.  They are reported as errors, or only logged with
+// 'unknownkeys: warn'.
+
+const (
+	UNKNOWN_KEYS_ERROR = "error"
+	UNKNOWN_KEYS_WARN  = "warn"
+)
+
+// Common misspellings of config keys.
+var keyAliases = map[string]string{
+	"user":        "uid",
+	"username":    "uid",
+	"group":       "gid",
+	"groups":      "gid",
+	"depends":     "dependson",
+	"cwd":         "dir",
+	"directory":   "dir",
+	"workingdir":  "dir",
+	"command":     "start",
+	"environment": "env",
+}
+
+// A problem found at a position in a config file.
+type ConfigError struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%v: %v", e.File, e.Message)
+	}
+	return fmt.Sprintf("%v:%v: %v", e.File, e.Line, e.Message)
+}
+
+// Collects several config problems so they can be reported at once.
+type ConfigErrors []error
+
+func (e ConfigErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Appends err, if there is one.
+func (e *ConfigErrors) add(err error) {
+	if err == nil {
+		return
+	}
+	if errs, ok := err.(ConfigErrors); ok {
+		*e = append(*e, errs...)
+	} else {
+		*e = append(*e, err)
+	}
+}
+
+// Returns nil when no errors were collected.
+func (e ConfigErrors) errOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Returns the yaml key goyaml uses for a struct field.
+func yamlKey(field reflect.StructField) string {
+	tag := field.Tag.Get("yaml")
+	if tag != "" {
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			tag = tag[:comma]
+		}
+		if tag != "" {
+			return tag
+		}
+	}
+	return strings.ToLower(field.Name)
+}
+
+// Returns the yaml keys of a struct type, mapped to their field types.
+func yamlFields(typ reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("yaml") == "-" {
+			continue // unexported
+		}
+		fields[yamlKey(field)] = field.Type
+	}
+	return fields
+}
+
+// Checks a parsed yaml document against the type it will be unmarshaled
+// into.  Lines are looked up in the raw file contents.
+func checkSchema(file string, contents []byte, doc yamlMap,
+	typ reflect.Type) ConfigErrors {
+	checker := &schemaChecker{file: file, lines: yamlKeyLines(contents)}
+	checker.checkMap(nil, doc, typ, true)
+	sort.Sort(checker.errors)
+	return ConfigErrors(checker.errors)
+}
+
+type schemaChecker struct {
+	file   string
+	lines  map[string]int
+	errors configErrorsByLine
+}
+
+type configErrorsByLine ConfigErrors
+
+func (e configErrorsByLine) Len() int      { return len(e) }
+func (e configErrorsByLine) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e configErrorsByLine) Less(i, j int) bool {
+	a, b := e[i].(*ConfigError), e[j].(*ConfigError)
+	if a.Line == b.Line {
+		return a.Message < b.Message
+	}
+	return a.Line < b.Line
+}
+
+func (s *schemaChecker) errorf(path []string, format string,
+	args ...interface{}) {
+	s.errors = append(s.errors, &ConfigError{
+		File:    s.file,
+		Line:    s.lines[strings.Join(path, "\x00")],
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Checks the keys of a map against the fields of typ, taking out the ones
+// that don't fit.
+func (s *schemaChecker) checkMap(path []string, doc yamlMap,
+	typ reflect.Type, isRoot bool) {
+	fields := yamlFields(typ)
+	for key, value := range doc {
+		name := fmt.Sprint(key)
+		keyPath := append(append([]string{}, path...), name)
+		if isRoot && name == INCLUDE_KEY {
+			continue
+		}
+		fieldType, known := fields[name]
+		if !known {
+			s.errorf(keyPath, "unknown key '%v'%v", strings.Join(keyPath, "."),
+				suggestKey(name, fields))
+			delete(doc, key)
+			continue
+		}
+		if value, ok := s.checkValue(keyPath, value, fieldType); ok {
+			doc[key] = value
+		} else {
+			delete(doc, key)
+		}
+	}
+}
+
+// Checks a value against typ.  Returns the value with whatever didn't fit
+// taken out, or false if the value itself doesn't fit.
+func (s *schemaChecker) checkValue(path []string, value interface{},
+	typ reflect.Type) (interface{}, bool) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if value == nil {
+		return value, true
+	}
+	key := strings.Join(path, ".")
+	switch typ.Kind() {
+	case reflect.Struct:
+		doc, ok := asYamlMap(value)
+		if !ok {
+			s.errorf(path, "'%v' must be a map", key)
+			return nil, false
+		}
+		s.checkMap(path, doc, typ, false)
+		return doc, true
+	case reflect.Map:
+		doc, ok := asYamlMap(value)
+		if !ok {
+			s.errorf(path, "'%v' must be a map", key)
+			return nil, false
+		}
+		for name, item := range doc {
+			itemPath := append(append([]string{}, path...), fmt.Sprint(name))
+			if item, ok := s.checkValue(itemPath, item, typ.Elem()); ok {
+				doc[name] = item
+			} else {
+				delete(doc, name)
+			}
+		}
+		return doc, true
+	case reflect.Interface:
+		// checked when the config is validated
+		return value, true
+	case reflect.Slice:
+		items, ok := value.([]interface{})
+		if !ok {
+			s.errorf(path, "'%v' must be a list, not '%v'", key, value)
+			return nil, false
+		}
+		kept := []interface{}{}
+		for _, item := range items {
+			if item, ok := s.checkValue(path, item, typ.Elem()); ok {
+				kept = append(kept, item)
+			}
+		}
+		return kept, true
+	}
+	if _, isMap := asYamlMap(value); isMap {
+		s.errorf(path, "'%v' must be a single value, not a map", key)
+		return nil, false
+	}
+	if _, isList := value.([]interface{}); isList {
+		s.errorf(path, "'%v' must be a single value, not a list", key)
+		return nil, false
+	}
+	switch typ.Kind() {
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			s.errorf(path, "'%v' must be true or false, not '%v'", key, value)
+			return nil, false
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64:
+		switch value.(type) {
+		case int, int64, uint64:
+		default:
+			s.errorf(path, "'%v' must be a whole number, not '%v'", key, value)
+			return nil, false
+		}
+	case reflect.Float32, reflect.Float64:
+		switch value.(type) {
+		case int, int64, uint64, float64:
+		default:
+			s.errorf(path, "'%v' must be a number, not '%v'", key, value)
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func asYamlMap(value interface{}) (yamlMap, bool) {
+	switch value := value.(type) {
+	case yamlMap:
+		return value, true
+	case map[interface{}]interface{}:
+		return yamlMap(value), true
+	}
+	return nil, false
+}
+
+// Returns a "did you mean" hint for an unknown key.
+func suggestKey(name string, fields map[string]reflect.Type) string {
+	normalized := strings.ToLower(strings.NewReplacer("_", "", "-", "").
+		Replace(name))
+	candidates := []string{keyAliases[normalized], normalized}
+	for _, candidate := range candidates {
+		if _, known := fields[candidate]; known {
+			return fmt.Sprintf(", did you mean '%v'?", candidate)
+		}
+	}
+	best, bestDistance := "", 3
+	for field := range fields {
+		distance := levenshtein(normalized, field)
+		if distance < bestDistance || (distance == bestDistance && field < best) {
+			best, bestDistance = field, distance
+		}
+	}
+	if best != "" {
+		return fmt.Sprintf(", did you mean '%v'?", best)
+	}
+	return ""
+}
+
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+var yamlKeyLine = regexp.MustCompile(`^(\s*)(-\s+)?([^\s#'"-][^:#]*?|'[^']*'|"[^"]*")\s*:(\s|$)`)
+
+// Maps the path of every key in a block style yaml file to its line number.
+// Paths are joined with NUL.
+func yamlKeyLines(contents []byte) map[string]int {
+	type entry struct {
+		indent int
+		key    string
+	}
+	lines := map[string]int{}
+	stack := []entry{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		match := yamlKeyLine.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		indent := len(match[1]) + len(match[2])
+		key := strings.Trim(match[3], `'"`)
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		stack = append(stack, entry{indent, key})
+		path := make([]string, len(stack))
+		for i, e := range stack {
+			path[i] = e.key
+		}
+		if _, seen := lines[strings.Join(path, "\x00")]; !seen {
+			lines[strings.Join(path, "\x00")] = lineno
+		}
+	}
+	return lines
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"launchpad.net/goyaml"
+	"path/filepath"
+	"reflect"
+)
+
+type SchemaSuite struct{}
+
+var _ = Suite(&SchemaSuite{})
+
+const badConfig = `---
+processes:
+  dashboard:
+    description: The cloud foundry dashboard.
+    groups: vcap
+    dependson: opentsdb
+    pidfile: /tmp/dashboard.pid
+    start: /bin/true
+    user: vcap
+  opentsdb:
+    description: Open source time series database
+    pidfile: /tmp/opentsdb.pid
+    start: /bin/true
+    environment:
+      - FOO=bar
+`
+
+func writeBadConfig(c *C, settings string) (string, string) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "dashboard-gonit.yml")
+	if err := ioutil.WriteFile(path, []byte(badConfig), 0644); err != nil {
+		c.Fatal(err)
+	}
+	err := ioutil.WriteFile(filepath.Join(dir, "gonit.yml"), []byte(settings),
+		0644)
+	if err != nil {
+		c.Fatal(err)
+	}
+	return dir, path
+}
+
+func (s *SchemaSuite) TestUnknownKeys(c *C) {
+	dir, path := writeBadConfig(c, "persistfile: /tmp/.gonit.persist.yml\n")
+
+	configManager := &ConfigManager{}
+	err := configManager.LoadConfig(dir)
+	c.Assert(err, NotNil)
+	errs, ok := err.(ConfigErrors)
+	c.Assert(ok, Equals, true)
+	c.Assert(len(errs), Equals, 4)
+	c.Check(errs[0].Error(), Equals, path+":5: unknown key "+
+		"'processes.dashboard.groups', did you mean 'gid'?")
+	c.Check(errs[1].Error(), Equals, path+":6: "+
+		"'processes.dashboard.dependson' must be a list, not 'opentsdb'")
+	c.Check(errs[2].Error(), Equals, path+":9: unknown key "+
+		"'processes.dashboard.user', did you mean 'uid'?")
+	c.Check(errs[3].Error(), Equals, path+":14: unknown key "+
+		"'processes.opentsdb.environment', did you mean 'env'?")
+}
+
+func (s *SchemaSuite) TestUnknownKeysWarn(c *C) {
+	dir, _ := writeBadConfig(c, "unknownkeys: warn\n"+
+		"persistfile: /tmp/.gonit.persist.yml\n")
+
+	configManager := &ConfigManager{}
+	err := configManager.LoadConfig(dir)
+	c.Check(err, IsNil)
+	c.Check(len(configManager.schemaErrors), Equals, 4)
+	// the bad dependson is left out rather than unmarshaled
+	dashboard := configManager.ProcessGroups["dashboard"].Processes["dashboard"]
+	c.Check(dashboard.DependsOn, IsNil)
+	c.Check(dashboard.Start, Equals, "/bin/true")
+}
+
+func (s *SchemaSuite) TestWrongTypes(c *C) {
+	doc := yamlMap{}
+	contents := []byte("processpollinterval: often\n" +
+		"action_concurrency: 2\n" +
+		"daemon:\n" +
+		"  name: gonit\n" +
+		"  shell: sometimes\n")
+	c.Assert(goyaml.Unmarshal(contents, &doc), IsNil)
+	errs := checkSchema("gonit.yml", contents, doc, reflect.TypeOf(Settings{}))
+	c.Assert(len(errs), Equals, 2)
+	c.Check(errs[0].Error(), Equals, "gonit.yml:1: "+
+		"'processpollinterval' must be a whole number, not 'often'")
+	c.Check(errs[1].Error(), Equals, "gonit.yml:5: "+
+		"'daemon.shell' must be true or false, not 'sometimes'")
+
+	// what is left unmarshals cleanly
+	b, err := goyaml.Marshal(doc)
+	c.Assert(err, IsNil)
+	settings := &Settings{}
+	c.Assert(goyaml.Unmarshal(b, settings), IsNil)
+	c.Check(settings.ActionConcurrency, Equals, 2)
+	c.Check(settings.Daemon.Name, Equals, "gonit")
+}
+
+func (s *SchemaSuite) TestUnknownSettings(c *C) {
+	dir, _ := writeBadConfig(c, "unknownkeys: sometimes\n")
+
+	configManager := &ConfigManager{}
+	err := configManager.LoadConfig(dir)
+	c.Check(err, ErrorMatches, "Settings unknownkeys must be.*")
+}
+
+func (s *SchemaSuite) TestYamlKeyLines(c *C) {
+	lines := yamlKeyLines([]byte(badConfig))
+	c.Check(lines["processes"], Equals, 2)
+	c.Check(lines["processes\x00dashboard\x00start"], Equals, 8)
+	c.Check(lines["processes\x00opentsdb"], Equals, 10)
+	c.Check(lines["processes\x00opentsdb\x00start"], Equals, 13)
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"fmt"
+)
+
+// Commands are split into words the way a POSIX shell would, without
+// running one: words are separated by unquoted blanks, single quotes keep
+// everything up to the next single quote, and inside double quotes a
+// backslash only escapes '\', '"', '$' and '`'.  Anything that needs a real
+// shell, such as pipes, redirections, variables, globs or a leading '~', is
+// an error unless the process sets 'shell: true', in which case the command
+// is run with /bin/sh -c.
+
+const DEFAULT_SHELL = "/bin/sh"
+
+// Unquoted characters that only make sense to a shell.
+const shellMetachars = "|&;<>()`$*?["
+
+// Splits a command line into words.
+func SplitCommand(command string) ([]string, error) {
+	words := []string{}
+	var word []byte
+	inWord := false
+
+	for i := 0; i < len(command); i++ {
+		ch := command[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if inWord {
+				words = append(words, string(word))
+				word, inWord = nil, false
+			}
+		case ch == '\\':
+			if i+1 == len(command) {
+				return nil, fmt.Errorf("Trailing backslash in '%v'.", command)
+			}
+			i++
+			if command[i] != '\n' {
+				word = append(word, command[i])
+			}
+			inWord = true
+		case ch == '\'':
+			end := indexByteFrom(command, '\'', i+1)
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated single quote in '%v'.",
+					command)
+			}
+			word = append(word, command[i+1:end]...)
+			i, inWord = end, true
+		case ch == '"':
+			i++
+			for ; i < len(command) && command[i] != '"'; i++ {
+				if command[i] == '\\' && i+1 < len(command) &&
+					isDoubleQuoteEscape(command[i+1]) {
+					i++
+					if command[i] == '\n' {
+						continue // line continuation
+					}
+				} else if isDoubleQuoteMetachar(command[i]) {
+					return nil, fmt.Errorf("'%c' in double quotes in '%v' "+
+						"needs a shell, set 'shell: true' to use one.",
+						command[i], command)
+				}
+				word = append(word, command[i])
+			}
+			if i == len(command) {
+				return nil, fmt.Errorf("Unterminated double quote in '%v'.",
+					command)
+			}
+			inWord = true
+		case isShellMetachar(ch) || (ch == '~' && !inWord):
+			return nil, fmt.Errorf("Unquoted '%c' in '%v' needs a shell, set "+
+				"'shell: true' to use one.", ch, command)
+		default:
+			word = append(word, ch)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, string(word))
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("Empty command.")
+	}
+	return words, nil
+}
+
+func isDoubleQuoteEscape(ch byte) bool {
+	return ch == '\\' || ch == '"' || ch == '$' || ch == '`' || ch == '\n'
+}
+
+func isShellMetachar(ch byte) bool {
+	return indexByteFrom(shellMetachars, ch, 0) >= 0
+}
+
+// Returns whether a shell would expand ch inside double quotes.
+func isDoubleQuoteMetachar(ch byte) bool {
+	return ch == '$' || ch == '`'
+}
+
+func indexByteFrom(s string, ch byte, from int) int {
+	for i := from; i < len(s); i++ {
+		if s[i] == ch {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the argv a process runs a program with.
+func (p *Process) commandArgs(program string) ([]string, error) {
+	if p.Shell {
+		if program == "" {
+			return nil, fmt.Errorf("Empty command.")
+		}
+		return []string{DEFAULT_SHELL, "-c", program}, nil
+	}
+	return SplitCommand(program)
+}
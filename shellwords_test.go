@@ -0,0 +1,60 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type ShellwordsSuite struct{}
+
+var _ = Suite(&ShellwordsSuite{})
+
+func (s *ShellwordsSuite) TestSplitCommand(c *C) {
+	tests := []struct {
+		command string
+		words   []string
+	}{
+		{"/bin/web  start", []string{"/bin/web", "start"}},
+		{`/bin/web --name 'my web' --title "it's \"on\""`,
+			[]string{"/bin/web", "--name", "my web", "--title", `it's "on"`}},
+		{`/bin/web my\ web '' a"b"'c'`,
+			[]string{"/bin/web", "my web", "", "abc"}},
+		{`echo "\$HOME \d" '\$HOME' 2\>1`,
+			[]string{"echo", `$HOME \d`, `\$HOME`, "2>1"}},
+		{`ls '*.log' "a?" \[x] '~' a~b`,
+			[]string{"ls", "*.log", "a?", "[x]", "~", "a~b"}},
+	}
+	for _, test := range tests {
+		words, err := SplitCommand(test.command)
+		c.Check(err, IsNil)
+		c.Check(words, DeepEquals, test.words)
+	}
+
+	errors := map[string]string{
+		"/bin/web 'start":        "Unterminated single quote.*",
+		`/bin/web "start`:        "Unterminated double quote.*",
+		`/bin/web start\`:        "Trailing backslash.*",
+		"/bin/web > /tmp/log":    "Unquoted '>' .* set 'shell: true' to use one.",
+		"/bin/web start | tee x": "Unquoted '\\|' .*",
+		"/bin/web --home $HOME":  "Unquoted '\\$' .*",
+		`/bin/web "$HOME"`:       "'\\$' in double quotes .*",
+		"rm /tmp/*.log":          "Unquoted '\\*' .*",
+		"ls /tmp/web.?":          "Unquoted '\\?' .*",
+		"ls /tmp/web.[0-9]":      "Unquoted '\\[' .*",
+		"/bin/web ~/web.conf":    "Unquoted '~' .*",
+		"  ":                     "Empty command.",
+	}
+	for command, message := range errors {
+		_, err := SplitCommand(command)
+		c.Check(err, ErrorMatches, message)
+	}
+}
+
+func (s *ShellwordsSuite) TestShell(c *C) {
+	process := &Process{Shell: true}
+	argv, err := process.commandArgs("/bin/web > /tmp/log 2>&1")
+	c.Check(err, IsNil)
+	c.Check(argv, DeepEquals,
+		[]string{"/bin/sh", "-c", "/bin/web > /tmp/log 2>&1"})
+}
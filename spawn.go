@@ -0,0 +1,242 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Resource limits, the nice value, the CPU affinity, the umask and the chroot
+// of a process have to be set between fork and exec, which the exec package
+// has no hook for.
+// Processes that configure any of them are started through gonit itself:
+// the child runs gonit as a small helper that applies the settings, joins
+// the process' cgroup, drops to the process credentials and execs the real
+// program.
+
+const (
+	SPAWN_HELPER   = "gonit-spawn"
+	SPAWN_SPEC_ENV = "GONIT_SPAWN_SPEC"
+)
+
+// Resource limits of a process.  Each is a number, 'unlimited', or
+// 'soft:hard' to set the two limits apart.  The size limits (as, core,
+// memlock and stack) may use kb, mb and gb units.
+type Limits struct {
+	Nofile  string
+	Nproc   string
+	Core    string
+	As      string
+	Stack   string
+	Memlock string
+}
+
+const RLIM_INFINITY = ^uint64(0)
+
+// A limit in the Limits config and the resource it sets.
+type rlimitResource struct {
+	name     string
+	resource int
+	isSize   bool
+}
+
+// A resource limit the spawn helper sets.
+type rlimitSpec struct {
+	Resource int
+	Soft     uint64
+	Hard     uint64
+}
+
+// Everything the spawn helper needs to start a program.
+type spawnSpec struct {
+	Path       string
+	Rlimits    []rlimitSpec
+	Nice       int
+	Affinity   []int
+	Cgroup     string
+	Umask      int
+	Chroot     string
+	Dir        string
+	Credential bool
+	Uid        uint32
+	Gid        uint32
+	Groups     []uint32
+}
+
+// An effective resource limit of a running process.
+type ProcessLimit struct {
+	Name string
+	Soft uint64
+	Hard uint64
+}
+
+// Returns the limits of a process by name.
+func (l *Limits) byName() map[string]string {
+	return map[string]string{
+		"nofile":  l.Nofile,
+		"nproc":   l.Nproc,
+		"core":    l.Core,
+		"as":      l.As,
+		"stack":   l.Stack,
+		"memlock": l.Memlock,
+	}
+}
+
+// Returns whether the process has settings only the spawn helper can apply.
+func (p *Process) usesSpawnHelper() bool {
+	return p.Limits != nil || p.Nice != 0 || len(p.Affinity) != 0 ||
+		p.Cgroup != nil || p.Umask != "" || p.Chroot != ""
+}
+
+// Parses an octal umask such as "022".
+func parseUmask(umask string) (int, error) {
+	mask, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil || mask > 0777 {
+		return 0, fmt.Errorf("'%v' is not an octal umask", umask)
+	}
+	return int(mask), nil
+}
+
+// Parses one limit value, such as "1024", "unlimited" or "8mb:unlimited".
+func parseLimit(value string, isSize bool) (uint64, uint64, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) > 2 {
+		return 0, 0, fmt.Errorf("'%v' is not a limit", value)
+	}
+	limits := make([]uint64, len(parts))
+	for i, part := range parts {
+		limit, err := parseLimitAmount(strings.TrimSpace(part), isSize)
+		if err != nil {
+			return 0, 0, err
+		}
+		limits[i] = limit
+	}
+	soft, hard := limits[0], limits[len(limits)-1]
+	if soft > hard {
+		return 0, 0, fmt.Errorf("soft limit of '%v' is above the hard limit",
+			value)
+	}
+	return soft, hard, nil
+}
+
+func parseLimitAmount(amount string, isSize bool) (uint64, error) {
+	if amount == "unlimited" {
+		return RLIM_INFINITY, nil
+	}
+	multiplier := uint64(1)
+	if isSize {
+		units := []struct {
+			suffix     string
+			multiplier uint64
+		}{{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024}}
+		for _, unit := range units {
+			if strings.HasSuffix(strings.ToLower(amount), unit.suffix) {
+				amount = amount[:len(amount)-len(unit.suffix)]
+				multiplier = unit.multiplier
+				break
+			}
+		}
+	}
+	limit, err := strconv.ParseUint(amount, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("'%v' is not a limit", amount)
+	}
+	return limit * multiplier, nil
+}
+
+// Returns the resource limits of the process for the spawn helper.
+func (p *Process) rlimits() ([]rlimitSpec, error) {
+	specs := []rlimitSpec{}
+	if p.Limits == nil {
+		return specs, nil
+	}
+	limits := p.Limits.byName()
+	for _, resource := range rlimitResources {
+		value := limits[resource.name]
+		if value == "" {
+			continue
+		}
+		soft, hard, err := parseLimit(value, resource.isSize)
+		if err != nil {
+			return nil, fmt.Errorf("limits %v: %v", resource.name, err)
+		}
+		specs = append(specs, rlimitSpec{resource.resource, soft, hard})
+	}
+	return specs, nil
+}
+
+// Checks the limits, nice value and CPU affinity of a process.
+func (p *Process) validateSpawnSettings() error {
+	if !p.usesSpawnHelper() {
+		return nil
+	}
+	errs := ConfigErrors{}
+	if !spawnHelperSupported {
+		errs.add(fmt.Errorf("Process %v: limits, nice, affinity, cgroup, umask "+
+			"and chroot are not supported on this platform.", p.FullName()))
+		return errs
+	}
+	if _, err := p.rlimits(); err != nil {
+		errs.add(fmt.Errorf("Process %v %v.", p.FullName(), err))
+	}
+	if p.Nice < -20 || p.Nice > 19 {
+		errs.add(fmt.Errorf("Process %v nice must be between -20 and 19, not "+
+			"%v.", p.FullName(), p.Nice))
+	}
+	for _, cpu := range p.Affinity {
+		if cpu < 0 || cpu >= maxAffinityCpus {
+			errs.add(fmt.Errorf("Process %v affinity has an invalid cpu %v.",
+				p.FullName(), cpu))
+		}
+	}
+	errs.add(p.validateCgroup())
+	if p.Umask != "" {
+		if _, err := parseUmask(p.Umask); err != nil {
+			errs.add(fmt.Errorf("Process %v umask %v.", p.FullName(), err))
+		}
+	}
+	if p.Chroot != "" && !filepath.IsAbs(p.Chroot) {
+		errs.add(fmt.Errorf("Process %v chroot '%v' is not an absolute path.",
+			p.FullName(), p.Chroot))
+	}
+	return errs.errOrNil()
+}
+
+// Makes cmd run through the spawn helper, which takes over setting the
+// credentials.
+func (p *Process) wrapSpawnHelper(cmd *exec.Cmd) error {
+	spec := &spawnSpec{Path: cmd.Path, Nice: p.Nice, Affinity: p.Affinity,
+		Umask: -1}
+	var err error
+	if spec.Rlimits, err = p.rlimits(); err != nil {
+		return err
+	}
+	if p.Cgroup != nil {
+		if err := p.setupCgroup(); err != nil {
+			return fmt.Errorf("Could not set up cgroup %v: %v", p.cgroupPath(),
+				err)
+		}
+		spec.Cgroup = p.cgroupPath()
+	}
+	if p.Umask != "" {
+		if spec.Umask, err = parseUmask(p.Umask); err != nil {
+			return err
+		}
+	}
+	if p.Chroot != "" {
+		// the directory is inside the chroot, so the helper changes to it
+		spec.Chroot, spec.Dir = p.Chroot, cmd.Dir
+		cmd.Dir = ""
+	}
+	if credential := cmd.SysProcAttr.Credential; credential != nil {
+		spec.Credential = true
+		spec.Uid, spec.Gid = credential.Uid, credential.Gid
+		spec.Groups = credential.Groups
+		cmd.SysProcAttr.Credential = nil
+	}
+	return setSpawnHelper(cmd, spec)
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2012 VMware, Inc.
+
+//go:build linux
+// +build linux
+
+package gonit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	spawnHelperSupported = true
+	maxAffinityCpus      = 1024
+)
+
+// not all of these are in the syscall package
+const (
+	rlimitNproc   = 6
+	rlimitMemlock = 8
+)
+
+var rlimitResources = []rlimitResource{
+	{"nofile", syscall.RLIMIT_NOFILE, false},
+	{"nproc", rlimitNproc, false},
+	{"core", syscall.RLIMIT_CORE, true},
+	{"as", syscall.RLIMIT_AS, true},
+	{"stack", syscall.RLIMIT_STACK, true},
+	{"memlock", rlimitMemlock, true},
+}
+
+func init() {
+	if len(os.Args) > 1 && os.Args[0] == SPAWN_HELPER {
+		runSpawnHelper()
+	}
+}
+
+// Runs cmd as the spawn helper, with spec in its environment.
+func setSpawnHelper(cmd *exec.Cmd, spec *spawnSpec) error {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	cmd.Path = "/proc/self/exe"
+	cmd.Args = append([]string{SPAWN_HELPER}, cmd.Args...)
+	cmd.Env = append(cmd.Env, SPAWN_SPEC_ENV+"="+string(data))
+	return nil
+}
+
+// Runs in the forked child before anything else: applies the spawn spec and
+// execs the program.  The nice value and affinity belong to the thread, so
+// they are set on the thread that calls exec.
+func runSpawnHelper() {
+	runtime.LockOSThread()
+
+	spec := &spawnSpec{}
+	if err := json.Unmarshal([]byte(os.Getenv(SPAWN_SPEC_ENV)), spec); err != nil {
+		spawnHelperFail(err)
+	}
+	env := []string{}
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, SPAWN_SPEC_ENV+"=") {
+			env = append(env, variable)
+		}
+	}
+
+	// join the cgroup while still privileged
+	if spec.Cgroup != "" {
+		procs := filepath.Join(spec.Cgroup, "cgroup.procs")
+		err := ioutil.WriteFile(procs, []byte(strconv.Itoa(os.Getpid())), 0644)
+		if err != nil {
+			spawnHelperFail(fmt.Errorf("joining cgroup: %v", err))
+		}
+	}
+	for _, limit := range spec.Rlimits {
+		rlimit := &syscall.Rlimit{Cur: limit.Soft, Max: limit.Hard}
+		if err := syscall.Setrlimit(limit.Resource, rlimit); err != nil {
+			spawnHelperFail(fmt.Errorf("setrlimit %v: %v", limit.Resource, err))
+		}
+	}
+	if spec.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0,
+			spec.Nice); err != nil {
+			spawnHelperFail(fmt.Errorf("setpriority: %v", err))
+		}
+	}
+	if len(spec.Affinity) != 0 {
+		if err := setAffinity(spec.Affinity); err != nil {
+			spawnHelperFail(fmt.Errorf("sched_setaffinity: %v", err))
+		}
+	}
+	if spec.Umask >= 0 {
+		syscall.Umask(spec.Umask)
+	}
+	if spec.Chroot != "" {
+		if err := syscall.Chroot(spec.Chroot); err != nil {
+			spawnHelperFail(fmt.Errorf("chroot %v: %v", spec.Chroot, err))
+		}
+		dir := spec.Dir
+		if dir == "" {
+			dir = "/"
+		}
+		if err := os.Chdir(dir); err != nil {
+			spawnHelperFail(err)
+		}
+	}
+	if spec.Credential {
+		groups := make([]int, len(spec.Groups))
+		for i, gid := range spec.Groups {
+			groups[i] = int(gid)
+		}
+		if err := syscall.Setgroups(groups); err != nil {
+			spawnHelperFail(fmt.Errorf("setgroups: %v", err))
+		}
+		if err := syscall.Setgid(int(spec.Gid)); err != nil {
+			spawnHelperFail(fmt.Errorf("setgid: %v", err))
+		}
+		if err := syscall.Setuid(int(spec.Uid)); err != nil {
+			spawnHelperFail(fmt.Errorf("setuid: %v", err))
+		}
+	}
+
+	err := syscall.Exec(spec.Path, os.Args[1:], env)
+	spawnHelperFail(fmt.Errorf("exec %v: %v", spec.Path, err))
+}
+
+func spawnHelperFail(err error) {
+	fmt.Fprintf(os.Stderr, "%v: %v\n", SPAWN_HELPER, err)
+	os.Exit(127)
+}
+
+type cpuMask [maxAffinityCpus / 64]uint64
+
+func setAffinity(cpus []int) error {
+	mask := cpuMask{}
+	for _, cpu := range cpus {
+		mask[cpu/64] |= 1 << uint(cpu%64)
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0,
+		unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Fills in the effective limits, nice value and affinity of pid.
+func readSpawnStatus(pid int, status *ProcessStatus) {
+	for _, resource := range rlimitResources {
+		rlimit := syscall.Rlimit{}
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid),
+			uintptr(resource.resource), 0, uintptr(unsafe.Pointer(&rlimit)), 0, 0)
+		if errno == 0 {
+			status.Limits = append(status.Limits,
+				ProcessLimit{resource.name, rlimit.Cur, rlimit.Max})
+		}
+	}
+
+	// the kernel returns 20 - nice
+	if priority, err := syscall.Getpriority(syscall.PRIO_PROCESS,
+		pid); err == nil {
+		status.Nice = 20 - priority
+	}
+
+	mask := cpuMask{}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY,
+		uintptr(pid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno == 0 {
+		for cpu := 0; cpu < maxAffinityCpus; cpu++ {
+			if mask[cpu/64]&(1<<uint(cpu%64)) != 0 {
+				status.Affinity = append(status.Affinity, cpu)
+			}
+		}
+	}
+}
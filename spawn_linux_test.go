@@ -0,0 +1,117 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"github.com/cloudfoundry/gosigar"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+type SpawnSuite struct{}
+
+var _ = Suite(&SpawnSuite{})
+
+func (s *SpawnSuite) TestParseLimit(c *C) {
+	soft, hard, err := parseLimit("1024", false)
+	c.Check(err, IsNil)
+	c.Check([]uint64{soft, hard}, DeepEquals, []uint64{1024, 1024})
+
+	soft, hard, err = parseLimit("8mb:unlimited", true)
+	c.Check(err, IsNil)
+	c.Check([]uint64{soft, hard}, DeepEquals,
+		[]uint64{8 * 1024 * 1024, RLIM_INFINITY})
+
+	_, _, err = parseLimit("8mb", false)
+	c.Check(err, ErrorMatches, "'8mb' is not a limit")
+	_, _, err = parseLimit("4096:1024", false)
+	c.Check(err, ErrorMatches, ".* is above the hard limit")
+}
+
+func (s *SpawnSuite) TestValidateSpawnSettings(c *C) {
+	process := &Process{
+		Name:     "db",
+		Limits:   &Limits{Nofile: "lots", Core: "0"},
+		Nice:     40,
+		Affinity: []int{0, -1},
+	}
+	err := process.validateSpawnSettings()
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Process db limits nofile: 'lots' is not a "+
+		"limit.\nProcess db nice must be between -20 and 19, not 40.\n"+
+		"Process db affinity has an invalid cpu -1.")
+}
+
+func (s *SpawnSuite) TestSpawnHelper(c *C) {
+	exits := make(chan string, 1)
+	process := &Process{
+		Name:      "sleeper",
+		Start:     "sleep 30",
+		Supervise: true,
+		Limits:    &Limits{Nofile: "256:512", Core: "0"},
+		Nice:      5,
+		Affinity:  []int{0},
+	}
+	pid, err := process.startProcess(exits)
+	c.Assert(err, IsNil)
+	defer func() {
+		syscall.Kill(pid, syscall.SIGKILL)
+		<-exits
+	}()
+
+	// wait for the helper to exec the program
+	state := sigar.ProcState{}
+	for i := 0; i < 50 && state.Name != "sleep"; i++ {
+		time.Sleep(20 * time.Millisecond)
+		state.Get(pid)
+	}
+	c.Assert(state.Name, Equals, "sleep")
+
+	status := &ProcessStatus{}
+	readSpawnStatus(pid, status)
+	limits := map[string]ProcessLimit{}
+	for _, limit := range status.Limits {
+		limits[limit.Name] = limit
+	}
+	c.Check(limits["nofile"], Equals, ProcessLimit{"nofile", 256, 512})
+	c.Check(limits["core"], Equals, ProcessLimit{"core", 0, 0})
+	c.Check(status.Nice, Equals, 5)
+	c.Check(status.Affinity, DeepEquals, []int{0})
+}
+
+func (s *SpawnSuite) TestUmaskAndChroot(c *C) {
+	dir := c.MkDir()
+	exits := make(chan string, 1)
+	process := &Process{
+		Name:      "umask",
+		Start:     "sh -c umask",
+		Supervise: true,
+		Umask:     "027",
+		Stdout:    filepath.Join(dir, "umask.out"),
+	}
+	_, err := process.startProcess(exits)
+	c.Assert(err, IsNil)
+	<-exits
+	output, err := ioutil.ReadFile(process.Stdout)
+	c.Check(err, IsNil)
+	c.Check(string(output), Equals, "0027\n")
+
+	process.Chroot = dir
+	_, err = process.lookPath("sh", []string{DEFAULT_ENV_PATH})
+	c.Check(err, ErrorMatches, `exec: "sh": executable file not found in `+
+		`chroot .*`)
+	c.Assert(os.Mkdir(filepath.Join(dir, "bin"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "bin", "sh"), nil, 0755), IsNil)
+	path, err := process.lookPath("sh", []string{DEFAULT_ENV_PATH})
+	c.Check(err, IsNil)
+	c.Check(path, Equals, "/bin/sh")
+
+	process.Umask, process.Chroot = "999", "jail"
+	c.Check(process.validateSpawnSettings(), ErrorMatches, "Process umask "+
+		"umask '999' is not an octal umask.\nProcess umask chroot 'jail' is "+
+		"not an absolute path.")
+}
@@ -0,0 +1,25 @@
+// Copyright (c) 2012 VMware, Inc.
+
+//go:build !linux
+// +build !linux
+
+package gonit
+
+import (
+	"errors"
+	"os/exec"
+)
+
+const (
+	spawnHelperSupported = false
+	maxAffinityCpus      = 0
+)
+
+var rlimitResources = []rlimitResource{}
+
+func setSpawnHelper(cmd *exec.Cmd, spec *spawnSpec) error {
+	return errors.New("limits, nice, affinity, cgroup, umask and chroot are " +
+		"not supported on this platform")
+}
+
+func readSpawnStatus(pid int, status *ProcessStatus) {}
@@ -0,0 +1,111 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// Processes with 'supervise: true' run in the foreground as children of
+// gonit, rather than daemonizing and writing their own pidfile.  gonit keeps
+// track of the child pid, writes the pidfile on its behalf if one is
+// configured, and reaps the child when it exits.  The Watcher is told about
+// the exit right away, on the channel Control starts the process with,
+// instead of finding out on its next poll.
+
+// A child process started in supervise mode.
+type supervisedChild struct {
+	sync.Mutex
+	pid    int
+	exited bool
+	state  *os.ProcessState
+}
+
+// Guards the child of every process, which is set when the process is
+// started while its pid may be looked up at the same time.
+var supervisedChildren sync.Mutex
+
+func (p *Process) supervisedChild() *supervisedChild {
+	supervisedChildren.Lock()
+	defer supervisedChildren.Unlock()
+	return p.child
+}
+
+func (p *Process) setSupervisedChild(child *supervisedChild) {
+	supervisedChildren.Lock()
+	defer supervisedChildren.Unlock()
+	p.child = child
+}
+
+// Returns the pid of the supervised child, if it is still running.
+func (p *Process) supervisedPid() (int, bool) {
+	child := p.supervisedChild()
+	if child == nil {
+		return 0, false
+	}
+	child.Lock()
+	defer child.Unlock()
+	return child.pid, !child.exited
+}
+
+// Starts tracking cmd, which has just been started, as the process' child.
+// Its full name is sent on exits, if not nil, once it exits.
+func (p *Process) supervise(cmd *exec.Cmd, exits chan<- string) {
+	child := &supervisedChild{pid: cmd.Process.Pid}
+	p.setSupervisedChild(child)
+	p.recordIdentity(child.pid)
+
+	if p.Pidfile != "" {
+		if err := p.SavePid(child.pid); err != nil {
+			Log.Errorf("Error saving pidfile of process %q: %v", p.FullName(),
+				err)
+		}
+	}
+
+	go func() {
+		err := cmd.Wait()
+
+		child.Lock()
+		child.exited = true
+		child.state = cmd.ProcessState
+		child.Unlock()
+
+		if p.Pidfile != "" {
+			if pid, _ := ReadPidFile(p.Pidfile); pid == child.pid {
+				os.Remove(p.Pidfile)
+			}
+		}
+		Log.Infof("Supervised process %q exited, pid=%d: %v", p.FullName(),
+			child.pid, exitString(cmd.ProcessState, err))
+		if cmd.ProcessState != nil {
+			p.recordExit(newExitStatus(EXIT_PROGRAM_START, cmd.ProcessState,
+				""))
+		}
+
+		if exits == nil {
+			return
+		}
+		select {
+		case exits <- p.FullName():
+		default:
+			// the Watcher will notice on its next poll
+		}
+	}()
+}
+
+// Describes how a child exited.
+func exitString(state *os.ProcessState, err error) string {
+	if state == nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	return state.String()
+}
+
+// Carries the runtime state of a process over from the process it replaces
+// in a reloaded config.
+func (p *Process) inheritRuntime(old *Process) {
+	p.setSupervisedChild(old.supervisedChild())
+}
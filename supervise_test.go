@@ -0,0 +1,66 @@
+// Copyright (c) 2012 VMware, Inc.
+
+package gonit
+
+import (
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+type SuperviseSuite struct{}
+
+var _ = Suite(&SuperviseSuite{})
+
+func (s *SuperviseSuite) TestSupervise(c *C) {
+	dir := c.MkDir()
+
+	exits := make(chan string, 1)
+	process := &Process{
+		Name:      "sleeper",
+		Start:     "sleep 60",
+		Pidfile:   filepath.Join(dir, "sleeper.pid"),
+		Supervise: true,
+		groupName: "supervise",
+	}
+	pid, err := process.startProcess(exits)
+	c.Assert(err, IsNil)
+	defer syscall.Kill(pid, syscall.SIGKILL)
+
+	c.Check(process.IsRunning(), Equals, true)
+	running, err := process.Pid()
+	c.Check(err, IsNil)
+	c.Check(running, Equals, pid)
+	saved, err := ReadPidFile(process.Pidfile)
+	c.Check(err, IsNil)
+	c.Check(saved, Equals, pid)
+
+	c.Assert(syscall.Kill(pid, syscall.SIGTERM), IsNil)
+	select {
+	case name := <-exits:
+		c.Check(name, Equals, "supervise/sleeper")
+	case <-time.After(5 * time.Second):
+		c.Fatal("no exit reported")
+	}
+	c.Check(process.IsRunning(), Equals, false)
+	_, err = os.Stat(process.Pidfile)
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SuperviseSuite) TestSuperviseWithoutPidfile(c *C) {
+	exits := make(chan string, 1)
+	process := &Process{Name: "sleeper", Start: "sleep 60", Supervise: true}
+	_, err := process.Pid()
+	c.Check(err, NotNil)
+
+	pid, err := process.startProcess(exits)
+	c.Assert(err, IsNil)
+	defer syscall.Kill(pid, syscall.SIGKILL)
+	c.Check(process.IsRunning(), Equals, true)
+
+	c.Assert(process.StopProcess(), IsNil)
+	<-exits
+	c.Check(process.IsRunning(), Equals, false)
+}
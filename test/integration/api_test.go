@@ -10,6 +10,7 @@ import (
 	"net/rpc"
 	"net/rpc/jsonrpc"
 	"os/exec"
+	"strings"
 	"time"
 )
 
@@ -111,11 +112,11 @@ func (s *ApiIntSuite) TestControl(c *C) {
 		c.Check(status.Summary.Running, Equals, false)
 	}
 
-	// control action in already progress; should fail
+	// control action already in progress by the start job; should fail
 	_, err = s.stopProcess(dopey)
 	msg := fmt.Sprintf(errorInProgressFmt, dopey.Name)
 	if c.Check(err, NotNil) {
-		c.Check(err.Error(), Equals, msg)
+		c.Check(strings.HasPrefix(err.Error(), msg+" by job "), Equals, true)
 	}
 
 	// but can control another process
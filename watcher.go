@@ -11,30 +11,32 @@ type Watcher struct {
 	Control *Control
 	quit    chan bool
 	notify  *psnotify.Watcher
-	pids    map[int]*Process
+	pids    map[int]string
 }
 
 func (w *Watcher) doCheckProcess(process *Process) error {
 	if !w.Control.monitorActivate(process) {
-		Log.Debugf("Process %q is not monitored", process.Name)
+		Log.Debugf("Process %q is not monitored", process.FullName())
 		return nil
 	}
 
 	if process.IsRunning() {
-		Log.Debugf("Process %q is running", process.Name)
+		Log.Debugf("Process %q is running", process.FullName())
 		return nil
 	}
 
-	Log.Debugf("Process %q is not running", process.Name)
+	Log.Debugf("Process %q is not running", process.FullName())
 
 	if !process.IsMonitoringModeActive() {
 		// TODO: alert if passive
-		Log.Debugf("Process %q MonitorMode is not active", process.Name)
+		Log.Debugf("Process %q MonitorMode is not active", process.FullName())
 		return nil
 	}
 
-	// TODO: flapping detection
-	Log.Debugf("Process %q: action start", process.Name)
+	if !w.Control.restartAllowed(process) {
+		return nil
+	}
+	Log.Debugf("Process %q: action start", process.FullName())
 
 	return w.Control.dispatchAction(process, NewControlAction(ACTION_START))
 }
@@ -42,25 +44,40 @@ func (w *Watcher) doCheckProcess(process *Process) error {
 func (w *Watcher) checkProcess(process *Process) {
 	// Control.invoke prevents other control actions from being run
 	// while we check and recover if the process isn't running
-	err := w.Control.invoke(process, func() error {
+	err := w.Control.invoke(process, 0, func() error {
 		return w.doCheckProcess(process)
 	})
 
 	if err != nil {
-		Log.Warnf("Error checking process %q: %v", process.Name, err)
+		Log.Warnf("Error checking process %q: %v", process.FullName(), err)
 	}
+	w.Control.persistIdentity(process)
+	w.Control.checkOomKills(process)
 
-	if w.usingNotify() {
+	// exits of supervised processes are reported by their Wait
+	if w.usingNotify() && !process.Supervise {
 		if pid, err := process.Pid(); err == nil {
 			if _, exists := w.pids[pid]; !exists {
 				w.notify.Watch(pid, psnotify.PROC_EVENT_EXIT)
-				w.pids[pid] = process
+				w.pids[pid] = process.FullName()
 			}
 		}
 	}
 }
 
+// Checks a process that was seen to exit.
+func (w *Watcher) checkExited(name string) {
+	w.Control.configLock.RLock()
+	defer w.Control.configLock.RUnlock()
+	// the process may have been removed by a config reload
+	if process, err := w.Control.Config().FindProcess(name); err == nil {
+		w.checkProcess(process)
+	}
+}
+
 func (w *Watcher) Check() {
+	w.Control.configLock.RLock()
+	defer w.Control.configLock.RUnlock()
 	for _, group := range w.Control.Config().ProcessGroups {
 		for _, process := range group.Processes {
 			w.checkProcess(process)
@@ -81,6 +98,7 @@ func (w *Watcher) run() {
 	if w.usingNotify() {
 		exits = w.notify.Exit
 	}
+	supervised := w.Control.exits()
 
 	for {
 		select {
@@ -89,12 +107,13 @@ func (w *Watcher) run() {
 			ticker.Stop()
 			return
 		case ev := <-exits:
-			if process, exists := w.pids[ev.Pid]; exists {
-				Log.Infof("Process %q exit, pid=%d",
-					process.Name, ev.Pid)
+			if name, exists := w.pids[ev.Pid]; exists {
+				Log.Infof("Process %q exit, pid=%d", name, ev.Pid)
 				delete(w.pids, ev.Pid)
-				w.checkProcess(process)
+				w.checkExited(name)
 			}
+		case name := <-supervised:
+			w.checkExited(name)
 		case <-ticker.C:
 			w.Check()
 		}
@@ -106,7 +125,7 @@ func (w *Watcher) Start() {
 	w.notify, err = psnotify.NewWatcher()
 	if err == nil {
 		Log.Info("psnotify: enabled")
-		w.pids = make(map[int]*Process)
+		w.pids = make(map[int]string)
 	} else {
 		Log.Warnf("psnotify disabled: %s", err)
 	}